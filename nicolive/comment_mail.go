@@ -0,0 +1,113 @@
+package nicolive
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sizes, positions and fonts of comments.
+const (
+	MailSizeBig       = "big"
+	MailSizeMedium    = "medium"
+	MailSizeSmall     = "small"
+	MailPositionUe    = "ue"
+	MailPositionNaka  = "naka"
+	MailPositionShita = "shita"
+	MailFontDefont    = "defont"
+	MailFontMincho    = "mincho"
+	MailFontGothic    = "gothic"
+)
+
+// mailColors is colors of the color commands.  Names with "2" and their aliases are only for premium members.
+var mailColors = map[string]string{
+	"white":  "#FFFFFF",
+	"red":    "#FF0000",
+	"pink":   "#FF8080",
+	"orange": "#FFC000",
+	"yellow": "#FFFF00",
+	"green":  "#00FF00",
+	"cyan":   "#00FFFF",
+	"blue":   "#0000FF",
+	"purple": "#C000FF",
+	"black":  "#000000",
+
+	"white2":         "#CCCC99",
+	"niconicowhite":  "#CCCC99",
+	"red2":           "#CC0033",
+	"truered":        "#CC0033",
+	"pink2":          "#FF33CC",
+	"orange2":        "#FF6600",
+	"passionorange":  "#FF6600",
+	"yellow2":        "#999900",
+	"madyellow":      "#999900",
+	"green2":         "#00CC66",
+	"elementalgreen": "#00CC66",
+	"cyan2":          "#00CCCC",
+	"blue2":          "#3399FF",
+	"marineblue":     "#3399FF",
+	"purple2":        "#6633CC",
+	"nobleviolet":    "#6633CC",
+	"black2":         "#666666",
+}
+
+var (
+	mailHexColorRegex = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+	mailDurationRegex = regexp.MustCompile(`^@([0-9]+)$`)
+)
+
+// MailCommand is commands in the mail field of a comment like "184 red big ue".
+type MailCommand struct {
+	Color    string   // Name of the color or "#rrggbb".  Empty for the default.
+	ColorHex string   // "#RRGGBB" of the color.  Empty for the default.
+	Size     string   // big, medium or small
+	Position string   // ue, naka or shita
+	Font     string   // defont, mincho or gothic.  Empty for the default.
+	Duration int      // Seconds to show the comment by "@N".  0 for the default.
+	Is184    bool     // Commented anonymously
+	Flags    []string // Other commands
+}
+
+// ParseMail parses commands in the mail field.
+// Commands are separated by spaces and case insensitive.
+// If a kind of commands appears more than once, the last one is used.
+func ParseMail(mail string) MailCommand {
+	mc := MailCommand{Size: MailSizeMedium, Position: MailPositionNaka}
+	for _, c := range strings.Fields(strings.ToLower(mail)) {
+		if hex, ok := mailColors[c]; ok {
+			mc.Color, mc.ColorHex = c, hex
+			continue
+		}
+		if mailHexColorRegex.MatchString(c) {
+			mc.Color, mc.ColorHex = c, strings.ToUpper(c)
+			continue
+		}
+		if m := mailDurationRegex.FindStringSubmatch(c); m != nil {
+			mc.Duration, _ = strconv.Atoi(m[1])
+			continue
+		}
+		switch c {
+		case MailSizeBig, MailSizeMedium, MailSizeSmall:
+			mc.Size = c
+		case MailPositionUe, MailPositionNaka, MailPositionShita:
+			mc.Position = c
+		case MailFontDefont, MailFontMincho, MailFontGothic:
+			mc.Font = c
+		case "184":
+			mc.Is184 = true
+		default:
+			mc.Flags = append(mc.Flags, c)
+		}
+	}
+	return mc
+}
+
+// MailCommand returns parsed commands in Mail.
+func (cm *Comment) MailCommand() MailCommand {
+	return ParseMail(cm.Mail)
+}
+
+// IsOverseas returns whether the comment is from a locale other than Japanese.
+func (cm *Comment) IsOverseas() bool {
+	return cm.Locale != "" && !strings.HasPrefix(strings.ToLower(cm.Locale), "ja")
+}
@@ -0,0 +1,32 @@
+package nicolive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMail(t *testing.T) {
+	tests := []struct {
+		mail string
+		mc   MailCommand
+	}{
+		{"", MailCommand{Size: MailSizeMedium, Position: MailPositionNaka}},
+		{"184 red big ue", MailCommand{Color: "red", ColorHex: "#FF0000", Size: MailSizeBig, Position: MailPositionUe, Is184: true}},
+		{"  Shita　SMALL  #00ff7f mincho ", MailCommand{Color: "#00ff7f", ColorHex: "#00FF7F", Size: MailSizeSmall, Position: MailPositionShita, Font: MailFontMincho}},
+		{"truered blue @5 invisible ender", MailCommand{Color: "blue", ColorHex: "#0000FF", Size: MailSizeMedium, Position: MailPositionNaka,
+			Duration: 5, Flags: []string{"invisible", "ender"}}},
+		{"#12345 @x", MailCommand{Size: MailSizeMedium, Position: MailPositionNaka, Flags: []string{"#12345", "@x"}}},
+	}
+	for i, test := range tests {
+		if mc := ParseMail(test.mail); !reflect.DeepEqual(mc, test.mc) {
+			t.Errorf("%d : expected %#v but %#v", i, test.mc, mc)
+		}
+	}
+
+	for locale, exp := range map[string]bool{"": false, "ja-jp": false, "en-us": true, "zh-tw": true} {
+		cm := Comment{Locale: locale}
+		if cm.IsOverseas() != exp {
+			t.Errorf("%s : overseas should be %v", locale, exp)
+		}
+	}
+}
@@ -20,6 +20,7 @@ const (
 	ErrIncorrectAccount
 	ErrNetwork
 	ErrDBUserNotFound
+	ErrDBLocked
 )
 
 // Error is an error struct in nicolive
@@ -49,6 +50,8 @@ func (e Error) TypeString() string {
 		s = "require_community_member"
 	case ErrIncorrectAccount:
 		s = "incorrect account"
+	case ErrDBLocked:
+		s = "locked DB"
 	}
 	return s
 }
@@ -1,12 +1,13 @@
 package nicolive
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 	"unicode"
 
-	"github.com/syndtr/goleveldb/leveldb"
 	"gopkg.in/xmlpath.v2"
 )
 
@@ -31,6 +32,73 @@ type User struct {
 	CreateTime   time.Time `json:"create_time"`
 	Is184        bool      `json:"is184"`
 	ThumbnailURL string    `json:"thumbnail_url"`
+
+	// Set by the user of Nagome
+	Note  string   `json:"note,omitempty"`
+	Tags  []string `json:"tags,omitempty"`  // e.g. regular, troll, mod
+	Color string   `json:"color,omitempty"` // Display color like "#ff0000"
+	Hide  bool     `json:"hide,omitempty"`  // Comments of the user should be hidden
+
+	CommunityNames map[string]string         `json:"community_names,omitempty"` // Names only used in the community
+	Communities    map[string]*UserCommunity `json:"communities,omitempty"`     // Comment history in each community
+}
+
+// UserCommunity is a comment history of a user in a community.
+type UserCommunity struct {
+	FirstSeen    time.Time `json:"first_seen"`     // Time of the first comment in the community
+	FirstBroad   string    `json:"first_broad"`    // ID of the broadcast of the first comment
+	FirstNo      int       `json:"first_no"`       // No of the first comment
+	LastSeen     time.Time `json:"last_seen"`      // Time of the last comment
+	Comments     int       `json:"comments"`       // Number of comments
+	Broad        string    `json:"broad"`          // ID of the broadcast of the last comment
+	BroadFirstNo int       `json:"broad_first_no"` // No of the first comment in Broad
+	LastNo       int       `json:"last_no"`        // No of the last comment in Broad
+	PrevLastSeen time.Time `json:"prev_last_seen"` // Time of the last comment before Broad
+}
+
+// Seen records a comment in a broadcast of the community.
+// Comments not newer than the last one in the same broadcast are ignored,
+// since they are sent again when reconnecting.
+// It returns the history in the community and whether it is updated.
+func (u *User) Seen(community, broad string, no int, t time.Time) (*UserCommunity, bool) {
+	if u.Communities == nil {
+		u.Communities = make(map[string]*UserCommunity)
+	}
+	c, ok := u.Communities[community]
+	if !ok {
+		c = &UserCommunity{FirstSeen: t, FirstBroad: broad, FirstNo: no}
+		u.Communities[community] = c
+	} else if c.Broad == broad && no <= c.LastNo {
+		return c, false
+	}
+	if !ok || c.Broad != broad {
+		c.PrevLastSeen = c.LastSeen
+		c.Broad = broad
+		c.BroadFirstNo = no
+	}
+	c.LastSeen = t
+	c.LastNo = no
+	c.Comments++
+	return c, true
+}
+
+// HasTag returns whether the user has the tag.
+func (u *User) HasTag(tag string) bool {
+	for _, t := range u.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NameIn returns the name of the user in the community.
+// It returns Name if the user has no name only for the community.
+func (u *User) NameIn(community string) string {
+	if n, ok := u.CommunityNames[community]; ok && community != "" {
+		return n
+	}
+	return u.Name
 }
 
 // CreateUser gather the user infomation of the given user id and returns pointer to new User struct.
@@ -67,7 +135,7 @@ func fetchUserInfoImpl(url string, a *Account) (user *User, err error) {
 
 	res, err := c.Get(url)
 	if err != nil {
-		return nil, ErrFromStdErr(err)
+		return nil, MakeError(ErrNetwork, "client.Get : "+err.Error())
 	}
 	defer func() {
 		if lerr := res.Body.Close(); lerr != nil {
@@ -76,6 +144,9 @@ func fetchUserInfoImpl(url string, a *Account) (user *User, err error) {
 			}
 		}
 	}()
+	if res.StatusCode >= 500 {
+		return nil, MakeError(ErrNetwork, "server error : "+res.Status)
+	}
 
 	root, err := xmlpath.Parse(res.Body)
 	if err != nil {
@@ -112,31 +183,153 @@ func (u *User) Equal(x *User) bool {
 		u.Name == x.Name &&
 		u.CreateTime.Unix() == x.CreateTime.Unix() &&
 		u.Is184 == x.Is184 &&
-		u.ThumbnailURL == x.ThumbnailURL
+		u.ThumbnailURL == x.ThumbnailURL &&
+		u.Note == x.Note &&
+		equalStrings(u.Tags, x.Tags) &&
+		u.Color == x.Color &&
+		u.Hide == x.Hide &&
+		equalStringMap(u.CommunityNames, x.CommunityNames) &&
+		equalCommunities(u.Communities, x.Communities)
+}
+
+func equalCommunities(a, b map[string]*UserCommunity) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		bv, ok := b[k]
+		if !ok || v.FirstBroad != bv.FirstBroad || v.FirstNo != bv.FirstNo ||
+			v.Comments != bv.Comments || v.Broad != bv.Broad || v.BroadFirstNo != bv.BroadFirstNo || v.LastNo != bv.LastNo ||
+			!v.FirstSeen.Equal(bv.FirstSeen) || !v.LastSeen.Equal(bv.LastSeen) || !v.PrevLastSeen.Equal(bv.PrevLastSeen) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStringMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
 }
 
 // UserDB is database of Users.
 type UserDB struct {
-	db *leveldb.DB
+	s  UserStorage
+	mu sync.Mutex // Locked while changing users so that the index is updated from the stored record
 }
 
-// NewUserDB creates new UserDB.
+// NewUserDB opens UserDB in a leveldb directory.
 func NewUserDB(dirname string) (*UserDB, error) {
-	db, err := leveldb.OpenFile(dirname, nil)
+	s, err := NewLevelDBUserStorage(dirname)
 	if err != nil {
-		return nil, ErrFromStdErr(err)
+		return nil, err
 	}
+	return NewUserDBWithStorage(s)
+}
 
-	return &UserDB{db}, nil
+// NewUserDBWithStorage makes UserDB on the storage.
+// Records in the storage are migrated to the current format.
+// The storage is closed if it fails.
+func NewUserDBWithStorage(s UserStorage) (*UserDB, error) {
+	d := &UserDB{s: s}
+	err := d.migrate()
+	if err == nil {
+		err = d.buildIndex()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 // Store stores a user into the DB.
 func (d *UserDB) Store(u *User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, err := d.Fetch(u.ID)
+	if err != nil {
+		if nerr, ok := err.(Error); !ok || nerr.Type() != ErrDBUserNotFound {
+			return err
+		}
+	}
+	return d.write(old, u)
+}
+
+// Update calls f with the user of the ID in the DB and stores the changed user.
+// Updates are serialized, so changes by other goroutines are not lost.
+// If the user is not in the DB, f is called with a new user.
+// The user is not stored if f doesn't change it.
+func (d *UserDB) Update(id string, f func(u *User)) (*User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, err := d.Fetch(id)
+	u := old
+	if err != nil {
+		if nerr, ok := err.(Error); !ok || nerr.Type() != ErrDBUserNotFound {
+			return nil, err
+		}
+		u = &User{
+			ID:         id,
+			CreateTime: time.Now(),
+			Is184:      Is184UserID(id),
+		}
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, ErrFromStdErr(err)
+	}
+	// old is not shared with f since the index is updated from it
+	nu := new(User)
+	if err := json.Unmarshal(b, nu); err != nil {
+		return nil, ErrFromStdErr(err)
+	}
+
+	f(nu)
+	nb, err := json.Marshal(nu)
+	if err != nil {
+		return nil, ErrFromStdErr(err)
+	}
+	if bytes.Equal(b, nb) {
+		return nu, nil
+	}
+	if err := d.write(old, nu); err != nil {
+		return nil, err
+	}
+	return nu, nil
+}
+
+// write stores u with the index changed from old.
+func (d *UserDB) write(old, u *User) error {
 	b, err := json.Marshal(u)
 	if err != nil {
 		return ErrFromStdErr(err)
 	}
-	err = d.db.Put([]byte(u.ID), b, nil)
+	batch := new(UserStorageBatch)
+	indexUser(batch, old, u)
+	batch.Put([]byte(u.ID), b)
+	err = d.s.Write(batch)
 	if err != nil {
 		return ErrFromStdErr(err)
 	}
@@ -146,9 +339,9 @@ func (d *UserDB) Store(u *User) error {
 // Fetch fetches a user of given ID from the DB.
 func (d *UserDB) Fetch(id string) (*User, error) {
 	var u = new(User)
-	b, err := d.db.Get([]byte(id), nil)
+	b, err := d.s.Get([]byte(id))
 	if err != nil {
-		if err == leveldb.ErrNotFound {
+		if err == ErrUserStorageNotFound {
 			return nil, MakeError(ErrDBUserNotFound, err.Error())
 		}
 		return nil, ErrFromStdErr(err)
@@ -160,9 +353,30 @@ func (d *UserDB) Fetch(id string) (*User, error) {
 	return u, nil
 }
 
+// Search returns users which match f in the order of IDs.
+// If limit is positive, it stops after finding limit users.
+func (d *UserDB) Search(f func(*User) bool, limit int) ([]*User, error) {
+	us, _, err := d.List("", limit, f)
+	return us, err
+}
+
 // Remove removes a user of given ID from the DB.
 func (d *UserDB) Remove(id string) error {
-	err := d.db.Delete([]byte(id), nil)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, err := d.Fetch(id)
+	if err != nil {
+		if nerr, ok := err.(Error); ok && nerr.Type() == ErrDBUserNotFound {
+			return nil
+		}
+		return err
+	}
+
+	batch := new(UserStorageBatch)
+	indexUser(batch, old, nil)
+	batch.Delete([]byte(id))
+	err = d.s.Write(batch)
 	if err != nil {
 		return ErrFromStdErr(err)
 	}
@@ -171,5 +385,5 @@ func (d *UserDB) Remove(id string) error {
 
 // Close closes the DB.
 func (d *UserDB) Close() error {
-	return d.db.Close()
+	return d.s.Close()
 }
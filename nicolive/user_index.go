@@ -0,0 +1,214 @@
+package nicolive
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys of the secondary index start with 0x00 so that they are not mixed with user IDs.
+const (
+	userNameIndexPrefix   = "\x00name\x00" // + lower case name + "\x00" + ID
+	userIndexVersionKey   = "\x00meta\x00index"
+	userIndexVersion      = "1"
+	userIndexKeySeparator = "\x00"
+)
+
+// userKeyRange is the range of keys of users.
+var userKeyRange = &util.Range{Start: []byte{0x01}}
+
+// userNames returns names of the user to be indexed.
+func userNames(u *User) []string {
+	var ns []string
+	add := func(n string) {
+		n = strings.ToLower(n)
+		if n == "" {
+			return
+		}
+		for _, c := range ns {
+			if c == n {
+				return
+			}
+		}
+		ns = append(ns, n)
+	}
+	add(u.Name)
+	for _, n := range u.CommunityNames {
+		add(n)
+	}
+	return ns
+}
+
+// userHasIndexedName returns whether the user currently has the name in the index.
+// Entries which don't match are stale.
+func userHasIndexedName(u *User, name string) bool {
+	for _, n := range userNames(u) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func userNameIndexKey(name, id string) []byte {
+	return []byte(userNameIndexPrefix + name + userIndexKeySeparator + id)
+}
+
+// parseUserNameIndexKey returns the name and the ID in the key.
+func parseUserNameIndexKey(k []byte) (name, id string, ok bool) {
+	if !bytes.HasPrefix(k, []byte(userNameIndexPrefix)) {
+		return "", "", false
+	}
+	k = k[len(userNameIndexPrefix):]
+	i := bytes.LastIndex(k, []byte(userIndexKeySeparator))
+	if i < 0 {
+		return "", "", false
+	}
+	return string(k[:i]), string(k[i+1:]), true
+}
+
+// indexUser adds changes of the index from old to u into the batch.
+// old can be nil.
+func indexUser(b *UserStorageBatch, old, u *User) {
+	if old != nil {
+		for _, n := range userNames(old) {
+			b.Delete(userNameIndexKey(n, old.ID))
+		}
+	}
+	if u != nil {
+		for _, n := range userNames(u) {
+			b.Put(userNameIndexKey(n, u.ID), nil)
+		}
+	}
+}
+
+// buildIndex makes the secondary index if the DB doesn't have the current version.
+func (d *UserDB) buildIndex() error {
+	v, err := d.s.Get([]byte(userIndexVersionKey))
+	if err == nil && string(v) == userIndexVersion {
+		return nil
+	}
+	if err != nil && err != ErrUserStorageNotFound {
+		return ErrFromStdErr(err)
+	}
+
+	b := new(UserStorageBatch)
+	rg := util.BytesPrefix([]byte(userNameIndexPrefix))
+	err = d.s.Iterate(rg.Start, rg.Limit, func(k, _ []byte) bool {
+		b.Delete(append([]byte(nil), k...))
+		return true
+	})
+	if err != nil {
+		return ErrFromStdErr(err)
+	}
+
+	err = d.s.Iterate(userKeyRange.Start, nil, func(_, v []byte) bool {
+		u := new(User)
+		if err := json.Unmarshal(v, u); err == nil {
+			indexUser(b, nil, u)
+		}
+		return true
+	})
+	if err != nil {
+		return ErrFromStdErr(err)
+	}
+
+	b.Put([]byte(userIndexVersionKey), []byte(userIndexVersion))
+	if err := d.s.Write(b); err != nil {
+		return ErrFromStdErr(err)
+	}
+	return nil
+}
+
+// List returns users which match f in the order of IDs.
+// It starts after the cursor, which is empty at first, and stops after finding limit users if limit is positive.
+// f can be nil to get all users.
+// The returned cursor is for the next page.  It is empty if there are no more users.
+func (d *UserDB) List(cursor string, limit int, f func(*User) bool) ([]*User, string, error) {
+	start := userKeyRange.Start
+	if cursor != "" {
+		start = []byte(cursor + userIndexKeySeparator)
+	}
+
+	var us []*User
+	var ferr error
+	next := ""
+	err := d.s.Iterate(start, nil, func(_, v []byte) bool {
+		if limit > 0 && len(us) >= limit {
+			next = us[len(us)-1].ID
+			return false
+		}
+		u := new(User)
+		if ferr = json.Unmarshal(v, u); ferr != nil {
+			return false
+		}
+		if f == nil || f(u) {
+			us = append(us, u)
+		}
+		return true
+	})
+	if err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return nil, "", ErrFromStdErr(err)
+	}
+	return us, next, nil
+}
+
+// ListByName returns users which have the name using the index in the order of names.
+// Names are compared in lower case.  The name is a prefix, or a substring if substr is true.
+// A user which has multiple matched names (e.g. names in communities) can be returned more than once.
+// The cursor, limit and f are same as List.
+func (d *UserDB) ListByName(name string, substr bool, cursor string, limit int, f func(*User) bool) ([]*User, string, error) {
+	name = strings.ToLower(name)
+	prefix := userNameIndexPrefix
+	if !substr {
+		prefix += name
+	}
+	rg := util.BytesPrefix([]byte(prefix))
+	if cursor != "" {
+		rg.Start = []byte(cursor + userIndexKeySeparator)
+	}
+
+	var us []*User
+	var ferr error
+	next, last := "", ""
+	err := d.s.Iterate(rg.Start, rg.Limit, func(k, _ []byte) bool {
+		if limit > 0 && len(us) >= limit {
+			next = last
+			return false
+		}
+		n, id, ok := parseUserNameIndexKey(k)
+		if !ok || (substr && !strings.Contains(n, name)) {
+			return true
+		}
+		var u *User
+		u, ferr = d.Fetch(id)
+		if ferr != nil {
+			if nerr, ok := ferr.(Error); ok && nerr.Type() == ErrDBUserNotFound {
+				// Stale entry of a removed user
+				ferr = nil
+				return true
+			}
+			return false
+		}
+		if !userHasIndexedName(u, n) {
+			return true
+		}
+		if f == nil || f(u) {
+			us = append(us, u)
+			last = string(k)
+		}
+		return true
+	})
+	if ferr != nil {
+		return nil, "", ferr
+	}
+	if err != nil {
+		return nil, "", ErrFromStdErr(err)
+	}
+	return us, next, nil
+}
@@ -0,0 +1,165 @@
+package nicolive
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func userIDs(us []*User) []string {
+	var s []string
+	for _, u := range us {
+		s = append(s, u.ID)
+	}
+	return s
+}
+
+func TestUserDBIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nagome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	db, err := NewUserDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*User{
+		{ID: "1", Name: "Alice"},
+		{ID: "2", Name: "bob", CommunityNames: map[string]string{"co1": "alicia"}},
+		{ID: "3", Name: "carol"},
+		{ID: "4"},
+	} {
+		if err := db.Store(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pagination
+	var ids []string
+	cursor := ""
+	for i := 0; ; i++ {
+		us, next, err := db.List(cursor, 3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, userIDs(us)...)
+		if next == "" {
+			break
+		}
+		if i > 2 {
+			t.Fatal("Too many pages")
+		}
+		cursor = next
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2", "3", "4"}) {
+		t.Fatalf("Unexpected users %v", ids)
+	}
+
+	tests := []struct {
+		name   string
+		substr bool
+		ids    []string
+	}{
+		{"ali", false, []string{"1", "2"}},
+		{"ALICE", false, []string{"1"}},
+		{"aro", false, nil},
+		{"aro", true, []string{"3"}},
+		{"b", true, []string{"2"}},
+	}
+	for i, test := range tests {
+		us, _, err := db.ListByName(test.name, test.substr, "", 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := userIDs(us); !reflect.DeepEqual(s, test.ids) {
+			t.Errorf("%d : expected %v but %v", i, test.ids, s)
+		}
+	}
+
+	us, next, err := db.ListByName("", false, "", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us2, _, err := db.ListByName("", false, next, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := userIDs(append(us, us2...)); !reflect.DeepEqual(s, []string{"1", "2", "2", "3"}) {
+		t.Fatalf("Unexpected pages by name %v", s)
+	}
+
+	// Updating and removing
+	if err := db.Store(&User{ID: "1", Name: "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Remove("3"); err != nil {
+		t.Fatal(err)
+	}
+	for name, exp := range map[string][]string{"alice": nil, "dave": {"1"}, "carol": nil} {
+		us, _, err := db.ListByName(name, false, "", 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := userIDs(us); !reflect.DeepEqual(s, exp) {
+			t.Errorf("%s : expected %v but %v", name, exp, s)
+		}
+	}
+
+	// Stale entries are not returned
+	b := new(UserStorageBatch)
+	b.Put(userNameIndexKey("erin", "1"), nil)
+	b.Put(userNameIndexKey("erin", "5"), nil)
+	if err := db.s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if us, _, err := db.ListByName("erin", false, "", 0, nil); err != nil || len(us) != 0 {
+		t.Fatalf("Stale entries are returned %v %v", userIDs(us), err)
+	}
+
+	// Rebuilding the index of an old DB
+	b = new(UserStorageBatch)
+	b.Delete([]byte(userIndexVersionKey))
+	rg := util.BytesPrefix([]byte(userNameIndexPrefix))
+	err = db.s.Iterate(rg.Start, rg.Limit, func(k, _ []byte) bool {
+		b.Delete(append([]byte(nil), k...))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewUserDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	us, _, err = db.ListByName("ali", false, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := userIDs(us); !reflect.DeepEqual(s, []string{"2"}) {
+		t.Fatalf("Index is not rebuilt %v", s)
+	}
+}
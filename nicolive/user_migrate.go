@@ -0,0 +1,89 @@
+package nicolive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// userSchemaVersionKey has the version of the format of user records.
+// DBs without it have version 1, the format before versioning.
+const userSchemaVersionKey = "\x00meta\x00schema"
+
+// userMigrations[i] migrates a user record of version i+1 to i+2.
+// Append a migration here when a change of User can't be read from old records as is
+// (e.g. renamed or retyped fields).
+// A record is a map decoded with json.Number for numbers.
+var userMigrations = []func(r map[string]interface{}) error{}
+
+func userSchemaVersion() int {
+	return len(userMigrations) + 1
+}
+
+// migrate converts all user records to the current version of the format.
+// The index is rebuilt after migration.
+func (d *UserDB) migrate() error {
+	ver := 1
+	v, err := d.s.Get([]byte(userSchemaVersionKey))
+	switch err {
+	case nil:
+		ver, err = strconv.Atoi(string(v))
+		if err != nil {
+			return MakeError(ErrOther, "broken schema version of the user DB : "+string(v))
+		}
+		if ver == userSchemaVersion() {
+			return nil
+		}
+	case ErrUserStorageNotFound:
+	default:
+		return ErrFromStdErr(err)
+	}
+	cur := userSchemaVersion()
+	if ver > cur {
+		return MakeError(ErrOther, fmt.Sprintf("user DB version %d is newer than supported version %d", ver, cur))
+	}
+
+	b := new(UserStorageBatch)
+	var merr error
+	err = d.s.Iterate(userKeyRange.Start, nil, func(k, v []byte) bool {
+		if ver == cur {
+			return false
+		}
+		r := make(map[string]interface{})
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber()
+		if merr = dec.Decode(&r); merr != nil {
+			merr = fmt.Errorf("user %s : %v", k, merr)
+			return false
+		}
+		for _, m := range userMigrations[ver-1:] {
+			if merr = m(r); merr != nil {
+				merr = fmt.Errorf("user %s : %v", k, merr)
+				return false
+			}
+		}
+		nv, err := json.Marshal(r)
+		if err != nil {
+			merr = err
+			return false
+		}
+		b.Put(append([]byte(nil), k...), nv)
+		return true
+	})
+	if err != nil {
+		return ErrFromStdErr(err)
+	}
+	if merr != nil {
+		return MakeError(ErrOther, "failed to migrate the user DB : "+merr.Error())
+	}
+
+	if b.Len() != 0 {
+		b.Delete([]byte(userIndexVersionKey))
+	}
+	b.Put([]byte(userSchemaVersionKey), []byte(strconv.Itoa(cur)))
+	if err := d.s.Write(b); err != nil {
+		return ErrFromStdErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package nicolive
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrUserStorageNotFound is returned by UserStorage.Get if the key doesn't exist.
+var ErrUserStorageNotFound = errors.New("not found in the user storage")
+
+// UserStorage is a sorted key-value storage used by UserDB.
+type UserStorage interface {
+	// Get returns the value of the key or ErrUserStorageNotFound.
+	Get(key []byte) ([]byte, error)
+	// Write applies all operations in the batch atomically.
+	Write(b *UserStorageBatch) error
+	// Iterate calls f with keys in [start, limit) in the order of keys until f returns false.
+	// limit can be nil to iterate to the end.
+	// The key and the value are only valid until f returns.
+	Iterate(start, limit []byte, f func(key, value []byte) bool) error
+	Close() error
+}
+
+type userStorageOp struct {
+	key, value []byte
+	del        bool
+}
+
+// UserStorageBatch is a list of operations written into a UserStorage at once.
+type UserStorageBatch struct {
+	ops []userStorageOp
+}
+
+// Put adds an operation to set the value of the key.
+func (b *UserStorageBatch) Put(key, value []byte) {
+	b.ops = append(b.ops, userStorageOp{key: key, value: value})
+}
+
+// Delete adds an operation to delete the key.
+func (b *UserStorageBatch) Delete(key []byte) {
+	b.ops = append(b.ops, userStorageOp{key: key, del: true})
+}
+
+// Len returns the number of operations in the batch.
+func (b *UserStorageBatch) Len() int {
+	return len(b.ops)
+}
+
+// levelDBUserStorage is a UserStorage in a leveldb directory.
+type levelDBUserStorage struct {
+	db *leveldb.DB
+}
+
+// NewLevelDBUserStorage opens a leveldb in the directory as a UserStorage.
+// The directory is locked while it is opened, and an Error of ErrDBLocked is returned
+// if it is locked by another process.
+func NewLevelDBUserStorage(dirname string) (UserStorage, error) {
+	db, err := leveldb.OpenFile(dirname, nil)
+	if err != nil {
+		if isLockedError(err) {
+			return nil, MakeError(ErrDBLocked, "the user DB is used by another process : "+err.Error())
+		}
+		return nil, ErrFromStdErr(err)
+	}
+	return &levelDBUserStorage{db}, nil
+}
+
+func (s *levelDBUserStorage) Get(key []byte) ([]byte, error) {
+	v, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrUserStorageNotFound
+	}
+	return v, err
+}
+
+func (s *levelDBUserStorage) Write(b *UserStorageBatch) error {
+	lb := new(leveldb.Batch)
+	for _, op := range b.ops {
+		if op.del {
+			lb.Delete(op.key)
+		} else {
+			lb.Put(op.key, op.value)
+		}
+	}
+	return s.db.Write(lb, nil)
+}
+
+func (s *levelDBUserStorage) Iterate(start, limit []byte, f func(key, value []byte) bool) error {
+	it := s.db.NewIterator(&util.Range{Start: start, Limit: limit}, nil)
+	defer it.Release()
+	for it.Next() {
+		if !f(it.Key(), it.Value()) {
+			break
+		}
+	}
+	return it.Error()
+}
+
+func (s *levelDBUserStorage) Close() error {
+	return s.db.Close()
+}
+
+// memoryUserStorage is a UserStorage in memory.
+type memoryUserStorage struct {
+	mu   sync.RWMutex
+	keys []string // sorted
+	m    map[string][]byte
+}
+
+// NewMemoryUserStorage makes an empty UserStorage in memory.
+// It is lost when closed.
+func NewMemoryUserStorage() UserStorage {
+	return &memoryUserStorage{m: make(map[string][]byte)}
+}
+
+func (s *memoryUserStorage) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.m[string(key)]
+	if !ok {
+		return nil, ErrUserStorageNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (s *memoryUserStorage) Write(b *UserStorageBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, op := range b.ops {
+		k := string(op.key)
+		i := sort.SearchStrings(s.keys, k)
+		found := i < len(s.keys) && s.keys[i] == k
+		if op.del {
+			if found {
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+				delete(s.m, k)
+			}
+			continue
+		}
+		if !found {
+			s.keys = append(s.keys, "")
+			copy(s.keys[i+1:], s.keys[i:])
+			s.keys[i] = k
+		}
+		s.m[k] = append([]byte(nil), op.value...)
+	}
+	return nil
+}
+
+// Iterate doesn't hold the lock while calling f, so f can use the storage.
+func (s *memoryUserStorage) Iterate(start, limit []byte, f func(key, value []byte) bool) error {
+	k := string(start)
+	for first := true; ; first = false {
+		s.mu.RLock()
+		i := sort.SearchStrings(s.keys, k)
+		if !first && i < len(s.keys) && s.keys[i] == k {
+			i++
+		}
+		if i >= len(s.keys) {
+			s.mu.RUnlock()
+			return nil
+		}
+		k = s.keys[i]
+		v := s.m[k]
+		s.mu.RUnlock()
+
+		if limit != nil && bytes.Compare([]byte(k), limit) >= 0 {
+			return nil
+		}
+		if !f([]byte(k), v) {
+			return nil
+		}
+	}
+}
+
+func (s *memoryUserStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	s.m = make(map[string][]byte)
+	return nil
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package nicolive
+
+import "syscall"
+
+// isLockedError returns whether err is returned because the file is locked by another process.
+func isLockedError(err error) bool {
+	return err == syscall.EWOULDBLOCK || err == syscall.EAGAIN
+}
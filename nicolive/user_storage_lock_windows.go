@@ -0,0 +1,13 @@
+package nicolive
+
+import "syscall"
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLockedError returns whether err is returned because the file is locked by another process.
+func isLockedError(err error) bool {
+	return err == errorSharingViolation || err == errorLockViolation
+}
@@ -0,0 +1,150 @@
+package nicolive
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testUserStorage(t *testing.T, s UserStorage) {
+	b := new(UserStorageBatch)
+	b.Put([]byte("b"), []byte("2"))
+	b.Put([]byte("a"), []byte("1"))
+	b.Put([]byte("c"), []byte("3"))
+	b.Put([]byte("d"), []byte("4"))
+	b.Delete([]byte("c"))
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := s.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("Unexpected value %s %v", v, err)
+	}
+	if _, err := s.Get([]byte("c")); err != ErrUserStorageNotFound {
+		t.Fatalf("Should be %v but %v", ErrUserStorageNotFound, err)
+	}
+
+	tests := []struct {
+		start, limit string
+		stop         int
+		kvs          []string
+	}{
+		{"", "", 0, []string{"a1", "b2", "d4"}},
+		{"b", "", 0, []string{"b2", "d4"}},
+		{"a", "d", 0, []string{"a1", "b2"}},
+		{"aa", "c", 0, []string{"b2"}},
+		{"", "", 2, []string{"a1", "b2"}},
+	}
+	for i, test := range tests {
+		var limit []byte
+		if test.limit != "" {
+			limit = []byte(test.limit)
+		}
+		var kvs []string
+		err := s.Iterate([]byte(test.start), limit, func(k, v []byte) bool {
+			kvs = append(kvs, string(k)+string(v))
+			return test.stop == 0 || len(kvs) < test.stop
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(kvs, test.kvs) {
+			t.Errorf("%d : expected %v but %v", i, test.kvs, kvs)
+		}
+	}
+
+	// Using the storage while iterating
+	err := s.Iterate(nil, nil, func(k, v []byte) bool {
+		if _, err := s.Get(k); err != nil {
+			t.Fatal(err)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nagome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	s, err := NewLevelDBUserStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testUserStorage(t, s)
+	testUserStorage(t, NewMemoryUserStorage())
+
+	s, err = NewLevelDBUserStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLevelDBUserStorage(dir)
+	if nerr, ok := err.(Error); !ok || nerr.Type() != ErrDBLocked {
+		t.Fatalf("Should be locked but %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserDBMigrate(t *testing.T) {
+	s := NewMemoryUserStorage()
+	b := new(UserStorageBatch)
+	b.Put([]byte("1"), []byte(`{"id":"1","nick":"alice","create_time":"2017-01-01T00:00:00Z","count":12345678901234567}`))
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := userMigrations
+	defer func() { userMigrations = orig }()
+	userMigrations = append(append([]func(map[string]interface{}) error{}, orig...),
+		func(r map[string]interface{}) error {
+			r["name"] = r["nick"]
+			delete(r, "nick")
+			return nil
+		})
+
+	db, err := NewUserDBWithStorage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Get([]byte(userSchemaVersionKey))
+	if err != nil || string(v) != "2" {
+		t.Fatalf("Unexpected version %s %v", v, err)
+	}
+	v, err = s.Get([]byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"count":12345678901234567,"create_time":"2017-01-01T00:00:00Z","id":"1","name":"alice"}`; string(v) != exp {
+		t.Fatalf("Should be %s but %s", exp, v)
+	}
+	us, _, err := db.ListByName("alice", false, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := userIDs(us); !reflect.DeepEqual(s, []string{"1"}) {
+		t.Fatalf("Index is not rebuilt %v", s)
+	}
+
+	// Newer DBs can't be opened
+	userMigrations = orig
+	if _, err := NewUserDBWithStorage(s); err == nil {
+		t.Fatal("Should fail for a newer DB")
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var (
@@ -41,6 +45,11 @@ func TestUserFetchInfo(t *testing.T) {
 			fmt.Fprintln(w, userInfoResponseNotFound)
 		},
 	)
+	mux.HandleFunc("/unavailable",
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		},
+	)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
 
@@ -62,6 +71,13 @@ func TestUserFetchInfo(t *testing.T) {
 	if nerr == nil {
 		t.Fatal(nerr)
 	}
+	if nerr.(Error).Type() == ErrNetwork {
+		t.Fatal("Not found should not be a network error")
+	}
+	_, nerr = fetchUserInfoImpl(ts.URL+"/unavailable", a)
+	if err, ok := nerr.(Error); !ok || err.Type() != ErrNetwork {
+		t.Fatalf("Should be a network error but %v", nerr)
+	}
 }
 
 func TestUserDB(t *testing.T) {
@@ -93,6 +109,11 @@ func TestUserDB(t *testing.T) {
 		CreateTime:   time.Now(),
 		Is184:        false,
 		ThumbnailURL: "url",
+
+		CommunityNames: map[string]string{"co1": "co1name"},
+	}
+	if su.NameIn("co1") != "co1name" || su.NameIn("co2") != "name" || su.NameIn("") != "name" {
+		t.Fatalf("Unexpected names in communities %v", su)
 	}
 
 	err = db.Store(su)
@@ -132,3 +153,144 @@ func TestUserDB(t *testing.T) {
 		t.Fatalf("Should be %v but %v", nil, fu)
 	}
 }
+
+func TestUserDBUpdate(t *testing.T) {
+	db, err := NewUserDBWithStorage(NewMemoryUserStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Not stored without changes
+	u, err := db.Update("1", func(u *User) {})
+	if err != nil || u.ID != "1" {
+		t.Fatalf("Unexpected user %v %v", u, err)
+	}
+	if _, err := db.Fetch("1"); err == nil {
+		t.Fatal("Unchanged new user should not be stored")
+	}
+
+	// Concurrent updates are not lost
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.Update("1", func(u *User) {
+				u.Tags = append(u.Tags, strconv.Itoa(i))
+				u.Name = "name" + strconv.Itoa(i)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	u, err = db.Fetch("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Tags) != n {
+		t.Fatalf("Updates are lost %v", u.Tags)
+	}
+	us, _, err := db.ListByName("name", false, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 1 {
+		t.Fatalf("Index is not updated from the stored user %v", userIDs(us))
+	}
+	cnt := 0
+	rg := util.BytesPrefix([]byte(userNameIndexPrefix))
+	err = db.s.Iterate(rg.Start, rg.Limit, func(_, _ []byte) bool {
+		cnt++
+		return true
+	})
+	if err != nil || cnt != 1 {
+		t.Fatalf("Should be 1 index entry but %d %v", cnt, err)
+	}
+}
+
+func TestUserSeen(t *testing.T) {
+	u := &User{ID: "1"}
+	tm := time.Now()
+
+	c, ok := u.Seen("co1", "lv1", 10, tm)
+	if !ok || c.Comments != 1 || c.FirstNo != 10 || c.BroadFirstNo != 10 || !c.PrevLastSeen.IsZero() {
+		t.Fatalf("Unexpected first comment %#v", c)
+	}
+	if _, ok = u.Seen("co1", "lv1", 10, tm); ok {
+		t.Fatal("Same comment should be ignored")
+	}
+	c, ok = u.Seen("co1", "lv1", 11, tm.Add(time.Second))
+	if !ok || c.Comments != 2 || c.BroadFirstNo != 10 || c.LastNo != 11 {
+		t.Fatalf("Unexpected second comment %#v", c)
+	}
+
+	c, ok = u.Seen("co1", "lv2", 1, tm.Add(time.Hour))
+	if !ok || c.Comments != 3 || c.FirstBroad != "lv1" || c.BroadFirstNo != 1 ||
+		!c.PrevLastSeen.Equal(tm.Add(time.Second)) {
+		t.Fatalf("Unexpected comment in next broadcast %#v", c)
+	}
+
+	c, ok = u.Seen("co2", "lv3", 5, tm)
+	if !ok || c.Comments != 1 || len(u.Communities) != 2 {
+		t.Fatalf("Unexpected comment in another community %#v", c)
+	}
+}
+
+func TestUserDBSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nagome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	db, err := NewUserDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, u := range []*User{
+		{ID: "3", Tags: []string{"regular"}},
+		{ID: "1", Tags: []string{"mod", "regular"}},
+		{ID: "2"},
+	} {
+		if err := db.Store(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(us []*User) (s []string) {
+		for _, u := range us {
+			s = append(s, u.ID)
+		}
+		return
+	}
+	us, err := db.Search(func(u *User) bool { return u.HasTag("regular") }, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ids(us); len(s) != 2 || s[0] != "1" || s[1] != "3" {
+		t.Fatalf("Unexpected result %v", s)
+	}
+	us, err = db.Search(func(u *User) bool { return true }, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ids(us); len(s) != 2 || s[0] != "1" || s[1] != "2" {
+		t.Fatalf("Unexpected result with limit %v", s)
+	}
+}
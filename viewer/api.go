@@ -14,8 +14,11 @@ type Message struct {
 	Domain  string          `json:"domain"`
 	Command string          `json:"command"`
 	Content json.RawMessage `json:"content,omitempty"` // The structure of Content is depend on the Command (and Domain).
+	Token   string          `json:"token,omitempty"`   // Set to messages to filter plugins.  Send it back with the filtered message.
 
-	plgno int
+	plgno  int
+	origin int     // No of the plugin which sent the message first.  It is kept through filter hops.
+	to     *Plugin // Destination of an internal message.  Only the plugin receives it if it is set.
 }
 
 func (m *Message) String() string {
@@ -41,6 +44,7 @@ func NewMessage(dom, com string, con interface{}) (*Message, error) {
 		Command: com,
 		Content: conj,
 		plgno:   -1,
+		origin:  -1,
 	}
 	return m, nil
 }
@@ -78,6 +82,7 @@ const (
 	CommNagomeAntennaOpen  = "Antenna.Open"
 	CommNagomeAntennaClose = "Antenna.Close"
 	CommNagomeUserUpdate   = "User.Update" // CommNagomeUserUpdate is Emitted when User info is updated by fetching or setting name etc.
+	CommNagomeUserFirst    = "User.First"  // Emitted when a user comments in the community for the first time.
 
 	// DomainComment
 	// This domain is for only sending comments.
@@ -99,18 +104,34 @@ const (
 	CommQuerySettingsSetCurrent = "Settings.SetCurrent" // Set settings to current slot.
 	CommQuerySettingsSetAll     = "Settings.SetAll"     // Set all slots of settings.
 
-	CommQueryPlugEnable = "Plug.Enable" // Enable or disable a plugin.
+	CommQueryPlugEnable    = "Plug.Enable"    // Enable or disable a plugin.
+	CommQueryPlugInstall   = "Plug.Install"   // Install or upgrade a plugin from a package.  It is loaded at next start.
+	CommQueryPlugUninstall = "Plug.Uninstall" // Uninstall a plugin.
+
+	CommQueryPlugSettingsSet = "Plug.Settings.Set" // Set a value of a setting declared in plugin.yml.
+	CommQueryPlugPermit      = "Plug.Permit"       // Grant or revoke permissions of a plugin.  Only the main plugin can send this.
+	CommQueryPlugRestart     = "Plug.Restart"      // Disconnect a normal plugin and launch it again.
 
 	CommQueryUserSet     = "User.Set"     // Set user info like name to the DB.
 	CommQueryUserSetName = "User.SetName" // Set user name to the DB.
 	CommQueryUserDelete  = "User.Delete"  // Delete user info from the DB.
-	CommQueryUserFetch   = "User.Fetch"   // Fetch user name from web page and update the internal user database.
+	CommQueryUserFetch   = "User.Fetch"   // Queue fetching user name from web page.  User.Update is emitted when fetched.
+
+	CommQueryUserSetNote  = "User.SetNote"  // Set a note of the user.
+	CommQueryUserSetTags  = "User.SetTags"  // Set tags of the user like regular, troll or mod.
+	CommQueryUserSetColor = "User.SetColor" // Set a display color of the user.
+	CommQueryUserSetHide  = "User.SetHide"  // Set whether comments of the user should be hidden.
+	CommQueryUserBackup   = "User.Backup"   // Export the user DB to a file in the backup directory in the save path.
+
+	CommQueryNGAdd    = "NG.Add"    // Add a rule to the NG filter in the current settings.
+	CommQueryNGRemove = "NG.Remove" // Remove a rule from the NG filter in the current settings.
 
 	// DomainUI
 	// Event to be processed by UI plugin.
-	CommUINotification  = "Notification"
-	CommUIClearComments = "ClearComments"
-	CommUIConfigAccount = "ConfigAccount" // Open the window of account setting or suggest user to configure it.
+	CommUINotification   = "Notification"
+	CommUIClearComments  = "ClearComments"
+	CommUIConfigAccount  = "ConfigAccount"   // Open the window of account setting or suggest user to configure it.
+	CommUIPlugPermission = "Plug.Permission" // Ask user to grant permissions of a plugin.  Answer by Plug.Permit query.
 
 	// DomainAntenna
 	// All antenna items (started live).
@@ -122,25 +143,74 @@ const (
 	// from plugin to Nagome
 	CommDirectAppVersion = "App.Version"
 
-	CommDirectNo       = "No"        // Tell plugin number to Nagome when the connection started.  (TCP at first time only)
-	CommDirectPlugList = "Plug.List" // Request a list of plugins.
+	CommDirectNo            = "No"             // Tell plugin number to Nagome when the connection started.  (TCP at first time only)
+	CommDirectTraceInject   = "Trace.Inject"   // Send instead of No to inject TraceRecords.  (TCP, tracing Nagome only)
+	CommDirectPlugList      = "Plug.List"      // Request a list of plugins.
+	CommDirectPlugInstalled = "Plug.Installed" // Request a list of installed plugins in the plugin directory.
 
 	CommDirectSettingsCurrent = "Settings.Current" // Request current settings message.
 	CommDirectSettingsAll     = "Settings.All"     // Request all slots of settings message.
 
-	CommDirectUserGet = "User.Get" // Get user info from the user DB.
+	CommDirectUserGet    = "User.Get"    // Get user info from the user DB.
+	CommDirectUserList   = "User.List"   // List users in the user DB in the order of IDs.
+	CommDirectUserSearch = "User.Search" // Search users in the user DB by name, note, tag etc.
+
+	CommDirectNGList = "NG.List" // Request rules of the NG filter.
+
+	// Storage for the plugin.  Keys are not shared with other plugins.
+	CommDirectPlugStorageGet    = "Plug.Storage.Get"
+	CommDirectPlugStorageSet    = "Plug.Storage.Set"
+	CommDirectPlugStorageDelete = "Plug.Storage.Delete"
+	CommDirectPlugStorageList   = "Plug.Storage.List"
+
+	CommDirectPlugSettingsGet = "Plug.Settings.Get" // Request settings declared in plugin.yml and current values.
+
+	CommDirectPlugSend = "Plug.Send" // Send a message to another plugin.
+
+	CommDirectPong = "Pong" // Answer to Ping.  Plugins which answer once are monitored by pings.
+
+	CommDirectStateGet = "State.Get" // Request current state of Nagome like the broadcast and connections.
+
+	CommDirectCommentBacklog = "Comment.Backlog" // Replay recent comments of the current broadcast to the plugin.
+
+	CommDirectTimerSet    = "Timer.Set"    // Register a timer.  Timer.Fired is sent when it fires.
+	CommDirectTimerCancel = "Timer.Cancel" // Cancel a timer.
+
+	CommDirectAPISchema = "API.Schema" // Request JSON Schemas of contents in the Message API.
 
 	// from Nagome to plugin
 	CommDirectngmAppVersion = "App.Version"
 
-	CommDirectngmPlugEnabled  = "Plug.Enabled"  // Sent when the plugin is enabled.
-	CommDirectngmPlugDisabled = "Plug.Disabled" // Sent when the plugin is disabled.
-	CommDirectngmPlugList     = "Plug.List"
+	CommDirectngmPlugEnabled   = "Plug.Enabled"  // Sent when the plugin is enabled.
+	CommDirectngmPlugDisabled  = "Plug.Disabled" // Sent when the plugin is disabled.
+	CommDirectngmPlugList      = "Plug.List"
+	CommDirectngmPlugInstalled = "Plug.Installed"
 
 	CommDirectngmSettingsCurrent = "Settings.Current"
 	CommDirectngmSettingsAll     = "Settings.All"
 
-	CommDirectngmUserGet = "User.Get"
+	CommDirectngmUserGet    = "User.Get"
+	CommDirectngmUserList   = "User.List"
+	CommDirectngmUserSearch = "User.Search"
+
+	CommDirectngmNGList = "NG.List"
+
+	CommDirectngmPlugStorageGet  = "Plug.Storage.Get"
+	CommDirectngmPlugStorageList = "Plug.Storage.List"
+
+	CommDirectngmPlugSettingsGet     = "Plug.Settings.Get"
+	CommDirectngmPlugSettingsChanged = "Plug.Settings.Changed" // Sent when a value of the settings of the plugin is changed.
+
+	CommDirectngmPlugMessage = "Plug.Message" // A message from another plugin sent by Plug.Send.
+
+	CommDirectngmPing = "Ping" // Sent periodically to check the plugin is alive.  Answer by Pong with the same seq.
+
+	CommDirectngmStateGet = "State.Get"
+
+	CommDirectngmTimerFired = "Timer.Fired"
+
+	CommDirectngmAPISchema = "API.Schema"
+	CommDirectngmError     = "Error" // Sent when a message from the plugin is rejected.
 )
 
 // Contents
@@ -169,7 +239,18 @@ type CtNagomeBroadInfo struct {
 }
 
 // CtNagomeUserUpdate is a content of CommNagomeUserUpdate
-type CtNagomeUserUpdate nicolive.User
+type CtNagomeUserUpdate struct {
+	nicolive.User
+	ThumbnailPath string `json:"thumbnail_path,omitempty"` // Path to the cached thumbnail
+}
+
+// CtNagomeUserFirst is a content of CommNagomeUserFirst
+type CtNagomeUserFirst struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	CommunityID string `json:"community_id"`
+	No          int    `json:"no"` // No of the comment
+}
 
 // CtQueryBroadConnect is a content of CommQueryBroadConnect
 type CtQueryBroadConnect struct {
@@ -210,6 +291,36 @@ type CtQueryPlugEnable struct {
 	Enable bool `json:"enable"`
 }
 
+// CtQueryPlugPermit is a content of CommQueryPlugPermit
+type CtQueryPlugPermit struct {
+	No          int      `json:"no"`
+	Permissions []string `json:"permissions"`
+	Allow       bool     `json:"allow"` // Revoke if false
+}
+
+// CtQueryPlugRestart is a content of CommQueryPlugRestart
+type CtQueryPlugRestart struct {
+	No int `json:"no"`
+}
+
+// CtQueryPlugInstall is a content of CommQueryPlugInstall
+type CtQueryPlugInstall struct {
+	Path    string `json:"path"` // zip, tar.gz file or directory
+	Upgrade bool   `json:"upgrade"`
+}
+
+// CtQueryPlugUninstall is a content of CommQueryPlugUninstall
+type CtQueryPlugUninstall struct {
+	Name string `json:"name"`
+}
+
+// CtQueryPlugSettingsSet is a content of CommQueryPlugSettingsSet
+type CtQueryPlugSettingsSet struct {
+	Name  string          `json:"name"` // plugin name
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
 // CtQueryUserSet is a content for CommQueryUserSet
 type CtQueryUserSet nicolive.User
 
@@ -224,11 +335,49 @@ type CtQueryUserDelete struct {
 	ID string `json:"id"`
 }
 
+// CtQueryUserSetNote is a content for CommQueryUserSetNote
+type CtQueryUserSetNote struct {
+	ID   string `json:"id"`
+	Note string `json:"note"`
+}
+
+// CtQueryUserSetTags is a content for CommQueryUserSetTags
+type CtQueryUserSetTags struct {
+	ID   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+// CtQueryUserSetColor is a content for CommQueryUserSetColor
+type CtQueryUserSetColor struct {
+	ID    string `json:"id"`
+	Color string `json:"color"` // "#rrggbb" or empty to clear
+}
+
+// CtQueryUserSetHide is a content for CommQueryUserSetHide
+type CtQueryUserSetHide struct {
+	ID   string `json:"id"`
+	Hide bool   `json:"hide"`
+}
+
+// CtQueryUserBackup is a content for CommQueryUserBackup
+type CtQueryUserBackup struct {
+	Format string `json:"format,omitempty"` // json (default) or csv
+}
+
 // CtQueryUserFetch is a content for CommQueryUserFetch
 type CtQueryUserFetch struct {
 	ID string `json:"id"`
 }
 
+// CtQueryNGAdd is a content for CommQueryNGAdd
+type CtQueryNGAdd struct {
+	Type  string `json:"type"`  // One of NGType* (word, regexp, user, 184, score)
+	Value string `json:"value"` // Word, regexp, user ID or minimum score.  Not used for "184".
+}
+
+// CtQueryNGRemove is a content for CommQueryNGRemove
+type CtQueryNGRemove CtQueryNGAdd
+
 // A CtCommentGot is a content of CommCommentGot
 type CtCommentGot struct {
 	No      int       `json:"no"`
@@ -239,11 +388,40 @@ type CtCommentGot struct {
 	UserID           string `json:"user_id"`
 	UserName         string `json:"user_name"`
 	UserThumbnailURL string `json:"user_thumbnail_url,omitempty"`
+	ThumbnailPath    string `json:"thumbnail_path,omitempty"` // Path to the cached thumbnail
 	Score            int    `json:"score,omitempty"`
 	IsPremium        bool   `json:"is_premium"`
 	IsBroadcaster    bool   `json:"is_broadcaster"`
 	IsStaff          bool   `json:"is_staff"`
 	IsAnonymity      bool   `json:"is_anonymity"`
+	Locale           string `json:"locale,omitempty"`      // e.g. ja-jp, en-us, zh-tw
+	IsOverseas       bool   `json:"is_overseas,omitempty"` // Commented from a locale other than Japanese
+
+	// Commands in the mail field
+	Mail         string   `json:"mail,omitempty"`           // Raw mail field
+	MailColor    string   `json:"mail_color,omitempty"`     // Name of the color like "red" or "#rrggbb"
+	MailColorHex string   `json:"mail_color_hex,omitempty"` // "#RRGGBB" of the color
+	MailSize     string   `json:"mail_size"`                // big, medium or small
+	MailPosition string   `json:"mail_position"`            // ue, naka or shita
+	MailFont     string   `json:"mail_font,omitempty"`      // defont, mincho or gothic
+	MailDuration int      `json:"mail_duration,omitempty"`  // Seconds to show the comment by "@N"
+	Mail184      bool     `json:"mail_184,omitempty"`       // "184" command
+	MailFlags    []string `json:"mail_flags,omitempty"`     // Other commands
+
+	// History of the user in the community.  They are not set if the community is unknown.
+	IsFirstInBroad     bool       `json:"is_first_in_broad,omitempty"`     // The first comment of the user in the broadcast
+	IsFirstInCommunity bool       `json:"is_first_in_community,omitempty"` // The first comment of the user in the community
+	LastSeen           *time.Time `json:"last_seen,omitempty"`             // Time of the last comment of the user before the broadcast
+	CommentCount       int        `json:"comment_count,omitempty"`         // Number of comments of the user in the community
+
+	// Set to the user by the user of Nagome
+	UserNote  string   `json:"user_note,omitempty"`
+	UserTags  []string `json:"user_tags,omitempty"`
+	UserColor string   `json:"user_color,omitempty"`
+	UserHide  bool     `json:"user_hide,omitempty"` // Comments of the user should be hidden
+
+	IsBacklog bool   `json:"is_backlog,omitempty"` // Replayed from the backlog of recent comments
+	NG        string `json:"ng,omitempty"`         // Type of the NG rule which the comment matched.  Set only if NG comments are marked.
 }
 
 // CtUINotification is a content of CommUINotification
@@ -254,6 +432,13 @@ type CtUINotification struct {
 	Description string `json:"description"`
 }
 
+// CtUIPlugPermission is a content of CommUIPlugPermission
+type CtUIPlugPermission struct {
+	No          int      `json:"no"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"` // Permissions which are not granted yet
+}
+
 // CtAntennaGot is a content of CommAntennaGot
 type CtAntennaGot struct {
 	BroadID     string `json:"broad_id"`
@@ -283,12 +468,21 @@ type CtDirectngmPlugList struct {
 	Plugins *[]*Plugin `json:"plugins"`
 }
 
+// CtDirectngmPlugInstalled is a content for CommDirectngmPlugInstalled
+type CtDirectngmPlugInstalled struct {
+	Plugins []InstalledPlugin `json:"plugins"`
+	Errors  []string          `json:"errors,omitempty"` // Plugins failed to load or conflicts of the names
+}
+
 // CtDirectngmSettingsCurrent is a content for CommDirectngmSettingsCurrent
 type CtDirectngmSettingsCurrent SettingsSlot
 
 // CtDirectngmSettingsAll is a content for CommDirectngmSettingsAll
 type CtDirectngmSettingsAll SettingsSlots
 
+// CtDirectngmNGList is a content for CommDirectngmNGList
+type CtDirectngmNGList NGSettings
+
 // CtDirectUserGet is a content for CommDirectUserGet
 type CtDirectUserGet struct {
 	ID string `json:"id"`
@@ -296,3 +490,178 @@ type CtDirectUserGet struct {
 
 // CtDirectngmUserGet is a content for CommDirectngmUserGet
 type CtDirectngmUserGet nicolive.User
+
+// CtDirectUserList is a content for CommDirectUserList
+type CtDirectUserList struct {
+	Cursor string `json:"cursor,omitempty"` // "next" of the previous page
+	Limit  int    `json:"limit,omitempty"`  // Default 100
+}
+
+// CtDirectngmUserList is a content for CommDirectngmUserList
+type CtDirectngmUserList struct {
+	Users []*nicolive.User `json:"users"`
+	Next  string           `json:"next,omitempty"` // Cursor for the next page.  Empty if there are no more users.
+}
+
+// CtDirectUserSearch is a content for CommDirectUserSearch
+// Users which match all given conditions are returned.
+type CtDirectUserSearch struct {
+	Query      string `json:"query,omitempty"`       // Part of ID, name or note (case insensitive)
+	Name       string `json:"name,omitempty"`        // Prefix of the name searched with the index (case insensitive)
+	NameSubstr bool   `json:"name_substr,omitempty"` // Search Name as a part of the name instead of a prefix
+	Tag        string `json:"tag,omitempty"`
+	Hide       *bool  `json:"hide,omitempty"`
+	Is184      *bool  `json:"is184,omitempty"`
+	HasName    *bool  `json:"has_name,omitempty"`
+
+	// Range of the time of the last comment.
+	// It is the one in Community, or in any community if Community is empty.
+	SeenAfter  *time.Time `json:"seen_after,omitempty"`
+	SeenBefore *time.Time `json:"seen_before,omitempty"`
+	Community  string     `json:"community,omitempty"`
+
+	Cursor string `json:"cursor,omitempty"` // "next" of the previous page
+	Limit  int    `json:"limit,omitempty"`  // Default 100
+}
+
+// CtDirectngmUserSearch is a content for CommDirectngmUserSearch
+type CtDirectngmUserSearch CtDirectngmUserList
+
+// CtDirectAPISchema is a content for CommDirectAPISchema
+type CtDirectAPISchema struct {
+	Domain  string `json:"domain,omitempty"`  // if omitted, all domains
+	Command string `json:"command,omitempty"` // if omitted, all commands
+}
+
+// CtDirectngmAPISchema is a content for CommDirectngmAPISchema
+type CtDirectngmAPISchema struct {
+	Schemas []APISchema `json:"schemas"`
+}
+
+// CtDirectngmError is a content for CommDirectngmError
+type CtDirectngmError struct {
+	Domain      string            `json:"domain"`
+	Command     string            `json:"command"`
+	Description string            `json:"description"`
+	Errors      []ValidationError `json:"errors,omitempty"`
+}
+
+// CtDirectPlugStorageGet is a content for CommDirectPlugStorageGet
+type CtDirectPlugStorageGet struct {
+	Key string `json:"key"`
+}
+
+// CtDirectngmPlugStorageGet is a content for CommDirectngmPlugStorageGet
+type CtDirectngmPlugStorageGet struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Found bool            `json:"found"`
+}
+
+// CtDirectPlugStorageSet is a content for CommDirectPlugStorageSet
+type CtDirectPlugStorageSet struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"` // any JSON value
+}
+
+// CtDirectPlugStorageDelete is a content for CommDirectPlugStorageDelete
+type CtDirectPlugStorageDelete struct {
+	Key string `json:"key"`
+}
+
+// CtDirectPlugStorageList is a content for CommDirectPlugStorageList
+type CtDirectPlugStorageList struct {
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// CtDirectngmPlugStorageList is a content for CommDirectngmPlugStorageList
+type CtDirectngmPlugStorageList struct {
+	Keys []string `json:"keys"`
+}
+
+// CtDirectPlugSettingsGet is a content for CommDirectPlugSettingsGet
+type CtDirectPlugSettingsGet struct {
+	Name string `json:"name,omitempty"` // plugin name.  if omitted, the plugin itself.  Other plugins need PluginPermPlugin.
+}
+
+// CtDirectngmPlugSettingsGet is a content for CommDirectngmPlugSettingsGet
+type CtDirectngmPlugSettingsGet struct {
+	Name     string                     `json:"name"`
+	Settings []PluginSetting            `json:"settings"`
+	Values   map[string]json.RawMessage `json:"values"`
+}
+
+// CtDirectngmPlugSettingsChanged is a content for CommDirectngmPlugSettingsChanged
+type CtDirectngmPlugSettingsChanged struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// CtDirectPlugSend is a content for CommDirectPlugSend
+// Set Name or No to specify the destination plugin.
+type CtDirectPlugSend struct {
+	Name    string          `json:"name,omitempty"`
+	No      *int            `json:"no,omitempty"`
+	Command string          `json:"command"` // Any command which the plugins agree on
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+// CtDirectngmPing is a content for CommDirectngmPing
+type CtDirectngmPing struct {
+	Seq int `json:"seq"`
+}
+
+// CtDirectPong is a content for CommDirectPong
+type CtDirectPong struct {
+	Seq int `json:"seq"`
+}
+
+// CtDirectCommentBacklog is a content for CommDirectCommentBacklog
+type CtDirectCommentBacklog struct {
+	Limit int `json:"limit,omitempty"` // Number of the newest comments.  0 for all.
+}
+
+// CtDirectngmStateGet is a content for CommDirectngmStateGet
+type CtDirectngmStateGet struct {
+	Broad     *CtNagomeBroadOpen `json:"broad"`      // null if not connected
+	BroadInfo *CtNagomeBroadInfo `json:"broad_info"` // Latest Broad.Info.  null if not received yet.
+	Connected bool               `json:"connected"`  // Connected to the comment server
+	Antenna   bool               `json:"antenna"`    // Connected to the antenna
+	LoggedIn  bool               `json:"logged_in"`  // The account has a user session
+	User      *CtStateUser       `json:"user"`       // The user in the current broadcast.  null if not connected.
+}
+
+// CtStateUser is the logged in user in CtDirectngmStateGet
+type CtStateUser struct {
+	UserID    string `json:"user_id"`
+	Name      string `json:"name"`
+	IsPremium bool   `json:"is_premium"`
+}
+
+// CtDirectTimerSet is a content for CommDirectTimerSet
+type CtDirectTimerSet struct {
+	Name          string `json:"name"`            // A timer with the same name is replaced
+	After         int    `json:"after"`           // Seconds until the first fire
+	Interval      int    `json:"interval"`        // Seconds between fires.  0 for a one-shot timer.
+	FromBroadOpen bool   `json:"from_broad_open"` // Count After from the open time of the current broadcast
+}
+
+// CtDirectTimerCancel is a content for CommDirectTimerCancel
+type CtDirectTimerCancel struct {
+	Name string `json:"name"`
+}
+
+// CtDirectngmTimerFired is a content for CommDirectngmTimerFired
+type CtDirectngmTimerFired struct {
+	Name  string    `json:"name"`
+	Count int       `json:"count"` // Number of fires including this
+	Time  time.Time `json:"time"`  // Scheduled time
+}
+
+// CtDirectngmPlugMessage is a content for CommDirectngmPlugMessage
+type CtDirectngmPlugMessage struct {
+	From    string          `json:"from"`    // Name of the sender plugin stamped by Nagome
+	FromNo  int             `json:"from_no"` // No of the sender plugin stamped by Nagome
+	Command string          `json:"command"`
+	Content json.RawMessage `json:"content,omitempty"`
+}
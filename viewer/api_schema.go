@@ -0,0 +1,394 @@
+package viewer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A JSONSchema is a subset of JSON Schema which describes a content of a Message.
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+// An APISchema is a schema of the content of a pair of a domain and a command.
+// Content is nil if the command has no content.
+type APISchema struct {
+	Domain          string      `json:"domain"`
+	Command         string      `json:"command"`
+	Content         *JSONSchema `json:"content"`
+	ContentOptional bool        `json:"content_optional,omitempty"`
+}
+
+// A ValidationError is an error of a field in a content.
+type ValidationError struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + " : " + e.Description
+}
+
+type apiContentType struct {
+	domain, command string
+	content         interface{} // nil if the command doesn't have content
+	optional        bool
+}
+
+// apiContentTypes is the list of all contents in the Message API.
+var apiContentTypes = []apiContentType{
+	{DomainNagome, CommNagomeBroadOpen, CtNagomeBroadOpen{}, false},
+	{DomainNagome, CommNagomeBroadClose, nil, false},
+	{DomainNagome, CommNagomeBroadInfo, CtNagomeBroadInfo{}, false},
+	{DomainNagome, CommNagomeCommentSend, nil, false},
+	{DomainNagome, CommNagomeAntennaOpen, nil, false},
+	{DomainNagome, CommNagomeAntennaClose, nil, false},
+	{DomainNagome, CommNagomeUserUpdate, CtNagomeUserUpdate{}, false},
+	{DomainNagome, CommNagomeUserFirst, CtNagomeUserFirst{}, false},
+
+	{DomainComment, CommCommentGot, CtCommentGot{}, false},
+
+	{DomainQuery, CommQueryBroadConnect, CtQueryBroadConnect{}, false},
+	{DomainQuery, CommQueryBroadDisconnect, nil, false},
+	{DomainQuery, CommQueryBroadSendComment, CtQueryBroadSendComment{}, false},
+	{DomainQuery, CommQueryAccountSet, CtQueryAccountSet{}, false},
+	{DomainQuery, CommQueryAccountLogin, nil, false},
+	{DomainQuery, CommQueryAccountLoad, nil, false},
+	{DomainQuery, CommQueryAccountSave, nil, false},
+	{DomainQuery, CommQueryLogPrint, CtQueryLogPrint{}, false},
+	{DomainQuery, CommQuerySettingsSetCurrent, CtQuerySettingsSetCurrent{}, false},
+	{DomainQuery, CommQuerySettingsSetAll, CtQuerySettingsSetAll{}, false},
+	{DomainQuery, CommQueryPlugEnable, CtQueryPlugEnable{}, false},
+	{DomainQuery, CommQueryPlugInstall, CtQueryPlugInstall{}, false},
+	{DomainQuery, CommQueryPlugUninstall, CtQueryPlugUninstall{}, false},
+	{DomainQuery, CommQueryPlugSettingsSet, CtQueryPlugSettingsSet{}, false},
+	{DomainQuery, CommQueryPlugPermit, CtQueryPlugPermit{}, false},
+	{DomainQuery, CommQueryPlugRestart, CtQueryPlugRestart{}, false},
+	{DomainQuery, CommQueryUserSet, CtQueryUserSet{}, false},
+	{DomainQuery, CommQueryUserSetName, CtQueryUserSetName{}, false},
+	{DomainQuery, CommQueryUserDelete, CtQueryUserDelete{}, false},
+	{DomainQuery, CommQueryUserFetch, CtQueryUserFetch{}, false},
+	{DomainQuery, CommQueryUserSetNote, CtQueryUserSetNote{}, false},
+	{DomainQuery, CommQueryUserSetTags, CtQueryUserSetTags{}, false},
+	{DomainQuery, CommQueryUserSetColor, CtQueryUserSetColor{}, false},
+	{DomainQuery, CommQueryUserSetHide, CtQueryUserSetHide{}, false},
+	{DomainQuery, CommQueryUserBackup, CtQueryUserBackup{}, true},
+	{DomainQuery, CommQueryNGAdd, CtQueryNGAdd{}, false},
+	{DomainQuery, CommQueryNGRemove, CtQueryNGRemove{}, false},
+
+	{DomainUI, CommUINotification, CtUINotification{}, false},
+	{DomainUI, CommUIClearComments, nil, false},
+	{DomainUI, CommUIConfigAccount, nil, false},
+	{DomainUI, CommUIPlugPermission, CtUIPlugPermission{}, false},
+
+	{DomainAntenna, CommAntennaGot, CtAntennaGot{}, false},
+
+	{DomainDirect, CommDirectAppVersion, nil, false},
+	{DomainDirect, CommDirectNo, CtDirectNo{}, false},
+	{DomainDirect, CommDirectTraceInject, nil, false},
+	{DomainDirect, CommDirectPlugList, nil, false},
+	{DomainDirect, CommDirectPlugInstalled, nil, false},
+	{DomainDirect, CommDirectSettingsCurrent, nil, false},
+	{DomainDirect, CommDirectSettingsAll, nil, false},
+	{DomainDirect, CommDirectNGList, nil, false},
+	{DomainDirect, CommDirectUserGet, CtDirectUserGet{}, false},
+	{DomainDirect, CommDirectUserList, CtDirectUserList{}, true},
+	{DomainDirect, CommDirectUserSearch, CtDirectUserSearch{}, true},
+	{DomainDirect, CommDirectPlugStorageGet, CtDirectPlugStorageGet{}, false},
+	{DomainDirect, CommDirectPlugStorageSet, CtDirectPlugStorageSet{}, false},
+	{DomainDirect, CommDirectPlugStorageDelete, CtDirectPlugStorageDelete{}, false},
+	{DomainDirect, CommDirectPlugStorageList, CtDirectPlugStorageList{}, true},
+	{DomainDirect, CommDirectPlugSettingsGet, CtDirectPlugSettingsGet{}, true},
+	{DomainDirect, CommDirectPlugSend, CtDirectPlugSend{}, false},
+	{DomainDirect, CommDirectPong, CtDirectPong{}, false},
+	{DomainDirect, CommDirectStateGet, nil, false},
+	{DomainDirect, CommDirectCommentBacklog, CtDirectCommentBacklog{}, true},
+	{DomainDirect, CommDirectTimerSet, CtDirectTimerSet{}, false},
+	{DomainDirect, CommDirectTimerCancel, CtDirectTimerCancel{}, false},
+	{DomainDirect, CommDirectAPISchema, CtDirectAPISchema{}, true},
+
+	{DomainDirectngm, CommDirectngmAppVersion, CtDirectngmAppVersion{}, false},
+	{DomainDirectngm, CommDirectngmPlugEnabled, nil, false},
+	{DomainDirectngm, CommDirectngmPlugDisabled, nil, false},
+	{DomainDirectngm, CommDirectngmPlugList, CtDirectngmPlugList{}, false},
+	{DomainDirectngm, CommDirectngmPlugInstalled, CtDirectngmPlugInstalled{}, false},
+	{DomainDirectngm, CommDirectngmSettingsCurrent, CtDirectngmSettingsCurrent{}, false},
+	{DomainDirectngm, CommDirectngmSettingsAll, CtDirectngmSettingsAll{}, false},
+	{DomainDirectngm, CommDirectngmNGList, CtDirectngmNGList{}, false},
+	{DomainDirectngm, CommDirectngmUserGet, CtDirectngmUserGet{}, false},
+	{DomainDirectngm, CommDirectngmUserList, CtDirectngmUserList{}, false},
+	{DomainDirectngm, CommDirectngmUserSearch, CtDirectngmUserSearch{}, false},
+	{DomainDirectngm, CommDirectngmPlugStorageGet, CtDirectngmPlugStorageGet{}, false},
+	{DomainDirectngm, CommDirectngmPlugStorageList, CtDirectngmPlugStorageList{}, false},
+	{DomainDirectngm, CommDirectngmPlugSettingsGet, CtDirectngmPlugSettingsGet{}, false},
+	{DomainDirectngm, CommDirectngmPlugSettingsChanged, CtDirectngmPlugSettingsChanged{}, false},
+	{DomainDirectngm, CommDirectngmPlugMessage, CtDirectngmPlugMessage{}, false},
+	{DomainDirectngm, CommDirectngmPing, CtDirectngmPing{}, false},
+	{DomainDirectngm, CommDirectngmStateGet, CtDirectngmStateGet{}, false},
+	{DomainDirectngm, CommDirectngmTimerFired, CtDirectngmTimerFired{}, false},
+	{DomainDirectngm, CommDirectngmAPISchema, CtDirectngmAPISchema{}, false},
+	{DomainDirectngm, CommDirectngmError, CtDirectngmError{}, false},
+}
+
+var (
+	apiSchemas     []APISchema
+	apiSchemaIndex = make(map[string]*APISchema)
+)
+
+func init() {
+	apiSchemas = make([]APISchema, len(apiContentTypes))
+	for i, c := range apiContentTypes {
+		apiSchemas[i] = APISchema{
+			Domain:          c.domain,
+			Command:         c.command,
+			ContentOptional: c.optional,
+		}
+		if c.content != nil {
+			apiSchemas[i].Content = NewJSONSchema(reflect.TypeOf(c.content))
+		}
+		apiSchemaIndex[c.domain+" "+c.command] = &apiSchemas[i]
+	}
+}
+
+// APISchemas returns schemas of all contents in the Message API.
+// If dom or com is not empty, only the schemas which match them are returned.
+func APISchemas(dom, com string) []APISchema {
+	var ss []APISchema
+	for _, s := range apiSchemas {
+		if dom != "" && dom != s.Domain {
+			continue
+		}
+		if com != "" && com != s.Command {
+			continue
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// FindAPISchema returns the schema of given domain and command.
+// The filter suffix of the domain is ignored.
+func FindAPISchema(dom, com string) (*APISchema, bool) {
+	s, ok := apiSchemaIndex[strings.TrimSuffix(dom, DomainSuffixFilter)+" "+com]
+	return s, ok
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// NewJSONSchema generates a JSONSchema from given Go type in the same way as encoding/json marshals it.
+// A recursive type is described as any value at the second appearance.
+func NewJSONSchema(t reflect.Type) *JSONSchema {
+	return newJSONSchema(t, make(map[reflect.Type]bool))
+}
+
+func newJSONSchema(t reflect.Type, visiting map[reflect.Type]bool) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string"}
+		}
+		return &JSONSchema{Type: "array", Items: newJSONSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: newJSONSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return &JSONSchema{}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		addStructProperties(s, t, visiting)
+		return s
+	}
+	return &JSONSchema{}
+}
+
+func addStructProperties(s *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructProperties(s, ft, visiting)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = newJSONSchema(f.Type, visiting)
+	}
+}
+
+// ValidateContent validates given raw content by the schema of the pair of the domain and the command.
+// It returns nil if the pair is unknown.
+func ValidateContent(dom, com string, content json.RawMessage) []ValidationError {
+	s, ok := FindAPISchema(dom, com)
+	if !ok {
+		return nil
+	}
+	if s.Content == nil {
+		return nil
+	}
+	if len(content) == 0 {
+		if s.ContentOptional {
+			return nil
+		}
+		return []ValidationError{{"content", "content is required"}}
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return []ValidationError{{"content", "invalid JSON : " + err.Error()}}
+	}
+	return s.Content.Validate("content", v)
+}
+
+// Validate validates given value decoded with UseNumber option.
+// null is accepted as any type like encoding/json does.
+func (s *JSONSchema) Validate(field string, v interface{}) []ValidationError {
+	if v == nil || s.Type == "" {
+		return nil
+	}
+	mismatch := func() []ValidationError {
+		return []ValidationError{{field, fmt.Sprintf("should be %s but %s", s.Type, jsonTypeName(v))}}
+	}
+
+	switch s.Type {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		if _, err := n.Int64(); err != nil {
+			return []ValidationError{{field, "should be integer but " + n.String()}}
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return mismatch()
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return []ValidationError{{field, "should be date-time (RFC 3339) but \"" + str + "\""}}
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		var errs []ValidationError
+		for i, e := range a {
+			errs = append(errs, s.Items.Validate(fmt.Sprintf("%s[%d]", field, i), e)...)
+		}
+		return errs
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var errs []ValidationError
+		for _, k := range keys {
+			ps := s.AdditionalProperties
+			if p := s.property(k); p != nil {
+				ps = p
+			}
+			if ps == nil {
+				continue
+			}
+			errs = append(errs, ps.Validate(field+"."+k, o[k])...)
+		}
+		return errs
+	}
+	return nil
+}
+
+// property finds a property case-insensitively as encoding/json does.
+func (s *JSONSchema) property(name string) *JSONSchema {
+	if p, ok := s.Properties[name]; ok {
+		return p
+	}
+	for k, p := range s.Properties {
+		if strings.EqualFold(k, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
@@ -0,0 +1,94 @@
+package viewer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewJSONSchema(t *testing.T) {
+	s := NewJSONSchema(reflect.TypeOf(CtCommentGot{}))
+	if s.Type != "object" {
+		t.Fatalf("Should be %v but %v", "object", s.Type)
+	}
+	tests := map[string]string{
+		"no":           "integer",
+		"date":         "string",
+		"comment":      "string",
+		"is_premium":   "boolean",
+		"is_staff":     "boolean",
+		"user_name":    "string",
+		"is_anonymity": "boolean",
+	}
+	for k, typ := range tests {
+		p, ok := s.Properties[k]
+		if !ok {
+			t.Fatalf("Property %v should exist", k)
+		}
+		if p.Type != typ {
+			t.Fatalf("Type of %v should be %v but %v", k, typ, p.Type)
+		}
+	}
+	if f := s.Properties["date"].Format; f != "date-time" {
+		t.Fatalf("Should be %v but %v", "date-time", f)
+	}
+
+	s = NewJSONSchema(reflect.TypeOf(CtDirectngmPlugList{}))
+	ps := s.Properties["plugins"]
+	if ps.Type != "array" || ps.Items.Type != "object" {
+		t.Fatalf("Should be array of object but %#v", ps)
+	}
+	if _, ok := ps.Items.Properties["exec"]; ok {
+		t.Fatalf("Fields tagged as \"-\" should be ignored")
+	}
+
+	s = NewJSONSchema(reflect.TypeOf(SettingsSlot{}))
+	if p := s.Properties["plugin_disable"]; p.Type != "object" || p.AdditionalProperties.Type != "boolean" {
+		t.Fatalf("Should be map of boolean but %#v", p)
+	}
+}
+
+func TestAPISchemasCoverCommands(t *testing.T) {
+	for _, s := range APISchemas("", "") {
+		if s.Domain == "" || s.Command == "" {
+			t.Fatalf("Empty domain or command : %v", s)
+		}
+	}
+	if ss := APISchemas(DomainQuery, CommQueryPlugEnable); len(ss) != 1 {
+		t.Fatalf("Should be %v but %v", 1, len(ss))
+	}
+	if _, ok := FindAPISchema(DomainComment+DomainSuffixFilter, CommCommentGot); !ok {
+		t.Fatalf("Filter suffix should be ignored")
+	}
+}
+
+func TestValidateContent(t *testing.T) {
+	tests := []struct {
+		dom, com, content string
+		fields            []string
+	}{
+		{DomainQuery, CommQueryPlugEnable, `{"no":1,"enable":true}`, nil},
+		{DomainQuery, CommQueryPlugEnable, `{"no":"1","enable":1}`, []string{"content.enable", "content.no"}},
+		{DomainQuery, CommQueryPlugEnable, `{"no":1.5}`, []string{"content.no"}},
+		{DomainQuery, CommQueryPlugEnable, ``, []string{"content"}},
+		{DomainQuery, CommQueryPlugEnable, `[1]`, []string{"content"}},
+		{DomainQuery, CommQueryBroadSendComment, `{"Text":"test","Iyayo":true}`, nil},
+		{DomainQuery, CommQueryBroadSendComment, `{"Text":1}`, []string{"content.Text"}},
+		{DomainQuery, CommQueryBroadDisconnect, ``, nil},
+		{DomainQuery, CommQuerySettingsSetCurrent, `{"plugin_disable":{"a":"b"}}`, []string{"content.plugin_disable.a"}},
+		{DomainComment + DomainSuffixFilter, CommCommentGot, `{"date":"yesterday"}`, []string{"content.date"}},
+		{DomainComment, CommCommentGot, `{"date":"2016-09-08T16:56:54.786312+09:00"}`, nil},
+		{"unknown", "unknown", `{"any":"thing"}`, nil},
+	}
+
+	for _, test := range tests {
+		errs := ValidateContent(test.dom, test.com, json.RawMessage(test.content))
+		var fields []string
+		for _, e := range errs {
+			fields = append(fields, e.Field)
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Fatalf("%s %s %s : Should be %v but %v", test.dom, test.com, test.content, test.fields, errs)
+		}
+	}
+}
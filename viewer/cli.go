@@ -1,12 +1,14 @@
 package viewer
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/diginatu/nagome/nicolive"
 )
@@ -19,6 +21,7 @@ const (
 	pluginDirName    = "plugin"
 	pluginConfigName = "plugin.yml"
 	userDBDirName    = "userdb"
+	plugDataDirName  = "plugindata"
 	settingsFileName = "setting.yml"
 )
 
@@ -28,6 +31,7 @@ type CLI struct {
 	OutStream, ErrStream io.WriteCloser
 	SavePath             string
 	SettingsSlots        SettingsSlots
+	MemoryStorage        bool // Keep the user DB and the plugin storage in memory instead of SavePath (for tests)
 	log                  *log.Logger
 	AppName, Version     string
 }
@@ -44,6 +48,11 @@ func NewCLI(name, appname string) *CLI {
 	}
 }
 
+// SetLogOutput sets the destination of the debug log.
+func (c *CLI) SetLogOutput(w io.Writer) {
+	c.log.SetOutput(w)
+}
+
 // RunCli runs CLI functions as one command line program.
 // This returns the CLI return value.
 func (c *CLI) RunCli(args []string) int {
@@ -64,6 +73,25 @@ func (c *CLI) RunCli(args []string) int {
 	flagst.BoolVar(&printHelp, "h", false, "Print this help. (shorthand)")
 	printVersion := flagst.Bool("v", false, "Print version information.")
 	mkplug := flagst.String("makeplug", "", "Make new plugin template with given name.")
+	mkplugLang := flagst.String("lang", "go", "Language of the plugin template. ("+strings.Join(PluginTemplateLangs(), ", ")+")")
+	mkplugMethod := flagst.String("method", pluginMethodTCP, "Connection method of the plugin template. (std, tcp)")
+	mkplugSubscribe := flagst.String("subscribe", DomainNagome+","+DomainComment, "Comma separated domains which the plugin template subscribes.")
+	plugInstall := flagst.String("plugininstall", "", "Install a plugin from given zip, tar.gz file or directory.")
+	plugUpgrade := flagst.String("pluginupgrade", "", "Upgrade a plugin from given zip, tar.gz file or directory.  Its data files are preserved.")
+	plugUninstall := flagst.String("pluginuninstall", "", "Uninstall the plugin with given name.")
+	checkPlug := flagst.String("checkplug", "", "Validate plugin.yml in given plugin directory, launch the plugin and report the result.")
+	plugList := flagst.Bool("pluginlist", false, "Print installed plugins.")
+	printAPISchema := flagst.Bool("apischema", false, "Print JSON Schemas of contents in the Nagome message API.")
+	userExport := flagst.String("userexport", "", "Export the user DB to given file.  Run while Nagome is not running.")
+	userImport := flagst.String("userimport", "", `Import users to the user DB from given file.  Run while Nagome is not running.
+	Users in json replace existing ones, and others are merged.`)
+	userFormat := flagst.String("userformat", "", `Format of -userexport and -userimport. (json, csv, namelist)
+	(in default, decided by the extension.  namelist is lines of ID and name separated by a tab or a comma)`)
+	traceFile := flagst.String("trace", "", "Record messages in the dispatcher to given file in JSON Lines.  It also enables -traceinject.")
+	tracePrint := flagst.String("traceprint", "", "Print given trace file in a readable format.")
+	traceInject := flagst.String("traceinject", "", "Inject messages in given trace file to running Nagome on the port of -p.")
+	traceFilter := flagst.String("tracefilter", "", `Filter records for -traceprint and -traceinject.
+	Comma separated key=value (keys : domain, command, plugin, event).`)
 	flagst.StringVar(&mainyml, "ymlmain", "", `specfy the config file of main plugin.
 	Its format is same as yml file of normal plugins.`)
 	flagst.StringVar(&mainyml, "y", "", `specfy the config file of main plugin. (shorthand)`)
@@ -88,12 +116,103 @@ func (c *CLI) RunCli(args []string) int {
 		fmt.Fprintln(c.OutStream, c.AppName, " ", c.Version)
 		return 0
 	}
+	if *plugInstall != "" || *plugUpgrade != "" {
+		src, upgrade := *plugInstall, false
+		if *plugUpgrade != "" {
+			src, upgrade = *plugUpgrade, true
+		}
+		ip, oldv, err := installPlugin(src, pluginPath, upgrade)
+		if err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		if oldv != "" {
+			fmt.Fprintf(c.OutStream, "Upgraded %s : %s -> %s\n", ip.Name, oldv, ip.Version)
+		} else {
+			fmt.Fprintf(c.OutStream, "Installed %s %s in : %s\n", ip.Name, ip.Version, filepath.Join(pluginPath, ip.Dir))
+		}
+		return 0
+	}
+	if *plugUninstall != "" {
+		ip, err := uninstallPlugin(*plugUninstall, pluginPath)
+		if err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		// Permissions are asked again if the plugin is installed again.
+		strg, err := newPluginStorage(filepath.Join(c.SavePath, plugDataDirName))
+		if err != nil {
+			c.log.Println("granted permissions are not removed : " + err.Error())
+		} else {
+			if err := strg.DeleteAll(pluginStorageNsPermissions, ip.Name); err != nil {
+				c.log.Println(err)
+			}
+			if err := strg.Close(); err != nil {
+				c.log.Println(err)
+			}
+		}
+		fmt.Fprintf(c.OutStream, "Uninstalled %s %s\n", ip.Name, ip.Version)
+		return 0
+	}
+	if *checkPlug != "" {
+		if err := checkPlugin(*checkPlug, c.OutStream); err != nil {
+			return 1
+		}
+		return 0
+	}
+	if *plugList {
+		ps, errs := listInstalledPlugins(pluginPath)
+		for _, p := range ps {
+			fmt.Fprintf(c.OutStream, "%s\t%s\t%s\n", p.Name, p.Version, p.Dir)
+		}
+		for _, err := range errs {
+			c.log.Println(err)
+		}
+		if len(errs) != 0 {
+			return 1
+		}
+		return 0
+	}
+	if *printAPISchema {
+		err = c.printAPISchema()
+		if err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		return 0
+	}
+	if *userExport != "" || *userImport != "" {
+		if err := c.runUserDBTool(*userExport, *userImport, *userFormat); err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		return 0
+	}
+	if *tracePrint != "" || *traceInject != "" {
+		f, err := parseTraceFilter(*traceFilter)
+		if err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		if err := c.runTraceTool(*tracePrint, *traceInject, "127.0.0.1:"+*tcpPort, f); err != nil {
+			c.log.Println(err)
+			return 1
+		}
+		return 0
+	}
 	if *mkplug != "" {
-		err = c.generatePluginTemplate(*mkplug, pluginPath)
+		opt := PluginTemplateOption{
+			Name:      *mkplug,
+			Lang:      *mkplugLang,
+			Method:    *mkplugMethod,
+			Subscribe: strings.Split(*mkplugSubscribe, ","),
+		}
+		p, err := generatePluginTemplate(opt, pluginPath)
 		if err != nil {
 			c.log.Println(err)
 			return 1
 		}
+		fmt.Fprintf(c.OutStream, "Create your plugin in : %s\n", p)
 		return 0
 	}
 
@@ -123,6 +242,12 @@ func (c *CLI) RunCli(args []string) int {
 	c.log.SetOutput(logw)
 
 	cv := NewCommentViewer(*tcpPort, c)
+	if *traceFile != "" {
+		if err := cv.EnableTrace(*traceFile); err != nil {
+			c.log.Println(err)
+			return 1
+		}
+	}
 
 	ac, err := nicolive.AccountLoad(filepath.Join(c.SavePath, accountFileName))
 	if err != nil {
@@ -174,31 +299,91 @@ func (c *CLI) RunCli(args []string) int {
 	return 0
 }
 
-func (c *CLI) generatePluginTemplate(name, pluginPath string) error {
-	p := filepath.Join(pluginPath, name)
+func (c *CLI) runTraceTool(printPath, injectPath, addr string, f traceFilter) error {
+	path := printPath
+	if path == "" {
+		path = injectPath
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := file.Close()
+		if err != nil {
+			c.log.Println(err)
+		}
+	}()
+
+	if printPath != "" {
+		return printTrace(file, c.OutStream, f)
+	}
+	n, err := injectTrace(file, addr, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.OutStream, "Injected %d messages\n", n)
+	return nil
+}
 
-	// check if the directory already exists
-	_, err := os.Stat(p)
-	if err == nil {
-		return fmt.Errorf("same name directory is already exists")
+func (c *CLI) runUserDBTool(exportPath, importPath, format string) (err error) {
+	path := exportPath
+	if path == "" {
+		path = importPath
+	}
+	if format == "" {
+		format = userFormatFromPath(path)
+	}
+	// Check before creating the file to export
+	if exportPath != "" && format != UserFormatJSON && format != UserFormatCSV {
+		return fmt.Errorf("unsupported format for export : %s", format)
 	}
 
-	if err := os.MkdirAll(p, 0777); err != nil {
-		return fmt.Errorf("Could not make save directory : %s", err)
+	db, err := nicolive.NewUserDB(filepath.Join(c.SavePath, userDBDirName))
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if cerr := db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 
-	pl := Plugin{
-		Name:      name,
-		Version:   "1.0",
-		Subscribe: []string{DomainNagome},
-		Method:    "tcp",
-		Exec:      []string{"{{path}}/" + name, "{{port}}", "{{no}}"},
+	if exportPath != "" {
+		file, err := os.Create(exportPath)
+		if err != nil {
+			return err
+		}
+		n, err := exportUsers(db, file, format)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.OutStream, "Exported %d users\n", n)
+		return nil
 	}
-	err = pl.Save(filepath.Join(p, pluginConfigName))
+
+	file, err := os.Open(importPath)
 	if err != nil {
-		return fmt.Errorf("failed to save file : %s", err)
+		return err
 	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			c.log.Println(cerr)
+		}
+	}()
+	n, err := importUsers(db, file, format)
+	fmt.Fprintf(c.OutStream, "Imported %d users\n", n)
+	return err
+}
 
-	fmt.Fprintf(c.OutStream, "Create your plugin in : %s\n", p)
-	return nil
+func (c *CLI) printAPISchema() error {
+	b, err := json.MarshalIndent(APISchemas("", ""), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.OutStream, "%s\n", b)
+	return err
 }
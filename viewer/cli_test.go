@@ -1,6 +1,7 @@
 package viewer
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -74,6 +75,36 @@ func TestCLIVersion(t *testing.T) {
 	}
 }
 
+func TestCLIAPISchema(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	cli := makeTestCLI(savepath)
+	b := new(bytes.Buffer)
+	cli.OutStream = &WriteNoClose{b}
+
+	rt := cli.RunCli([]string{DefaultAppName, "-apischema"})
+	if rt != 0 {
+		t.Fatalf("Return value should be %v but %v", 0, rt)
+	}
+
+	var ss []APISchema
+	if err := json.Unmarshal(b.Bytes(), &ss); err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != len(apiContentTypes) {
+		t.Fatalf("Should be %v but %v", len(apiContentTypes), len(ss))
+	}
+}
+
 func TestCLIQuit(t *testing.T) {
 	savepath, err := ioutil.TempDir("", DefaultAppName)
 	if err != nil {
@@ -0,0 +1,355 @@
+// Package client provides a client to write Nagome plugins in Go.
+//
+// A plugin creates a Client with NewStd or DialTCP, registers handlers, and calls Run.
+//
+//	c, err := client.DialTCP("localhost:"+port, no)
+//	if err != nil {
+//		log.Fatalln(err)
+//	}
+//	c.OnCommentGot(func(ct *viewer.CtCommentGot) {
+//		c.LogPrint("got : " + ct.Comment)
+//	})
+//	log.Fatalln(c.Run(context.Background()))
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diginatu/nagome/viewer"
+)
+
+const (
+	reconnectMinWait = time.Second
+	reconnectMaxWait = 30 * time.Second
+)
+
+// A HandlerFunc handles a message from Nagome.
+type HandlerFunc func(m *viewer.Message)
+
+// A FilterFunc filters a message in a domain subscribed with the filter suffix.
+// Returning nil drops the message.  Returned message is passed to the next filter or plugins.
+type FilterFunc func(m *viewer.Message) *viewer.Message
+
+// A Client is a connection from a plugin to Nagome.
+// Handlers are called in the goroutine of Run in order of received messages.
+// Don't call Request in handlers because responses are received in the same goroutine.
+type Client struct {
+	No int // Plugin number.  It is -1 for std plugins.
+
+	dial func() (io.ReadWriteCloser, error)
+
+	mu       sync.Mutex
+	rwc      io.ReadWriteCloser
+	enc      *json.Encoder
+	handlers map[string][]HandlerFunc
+	filters  map[string]FilterFunc
+	waiting  map[string][]chan *viewer.Message
+}
+
+// New creates new Client which communicates through given rwc.
+// The handshake is not done, so use DialTCP for TCP plugins.
+func New(rwc io.ReadWriteCloser, no int) *Client {
+	c := &Client{
+		No:       no,
+		handlers: make(map[string][]HandlerFunc),
+		filters:  make(map[string]FilterFunc),
+		waiting:  make(map[string][]chan *viewer.Message),
+	}
+	c.setConn(rwc)
+	return c
+}
+
+// NewStd creates new Client which uses stdin/out (method "std" in plugin.yml).
+func NewStd() *Client {
+	return New(&stdReadWriteCloser{os.Stdin, os.Stdout}, -1)
+}
+
+// DialTCP connects to Nagome at addr as the plugin number no (method "tcp" in plugin.yml).
+// The Client reconnects automatically in Run if the connection is closed.
+func DialTCP(addr string, no int) (*Client, error) {
+	c := New(nil, no)
+	c.dial = func() (io.ReadWriteCloser, error) {
+		return net.Dial("tcp", addr)
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	c.mu.Lock()
+	dial := c.dial
+	c.mu.Unlock()
+	if dial == nil {
+		return fmt.Errorf("closed")
+	}
+	rwc, err := dial()
+	if err != nil {
+		return err
+	}
+	c.setConn(rwc)
+	err = c.Send(viewer.DomainDirect, viewer.CommDirectNo, viewer.CtDirectNo{No: c.No})
+	if err != nil {
+		_ = rwc.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *Client) setConn(rwc io.ReadWriteCloser) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rwc = rwc
+	if rwc != nil {
+		c.enc = json.NewEncoder(rwc)
+	}
+}
+
+// Handle registers a handler for messages of given domain and command.
+// An empty command matches all commands in the domain.
+// Subscribe the domain in plugin.yml to receive messages.
+func (c *Client) Handle(dom, com string, h HandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := dom + " " + com
+	c.handlers[k] = append(c.handlers[k], h)
+}
+
+// Filter registers a filter for given domain.
+// Subscribe the domain with the filter suffix (e.g. "nagome_comment@filter") in plugin.yml.
+func (c *Client) Filter(dom string, f FilterFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters[strings.TrimSuffix(dom, viewer.DomainSuffixFilter)] = f
+}
+
+// Send sends a message to Nagome.
+func (c *Client) Send(dom, com string, ct interface{}) error {
+	m, err := viewer.NewMessage(dom, com, ct)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(m)
+}
+
+// SendMessage sends a message to Nagome.
+func (c *Client) SendMessage(m *viewer.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.enc == nil {
+		return fmt.Errorf("not connected")
+	}
+	return c.enc.Encode(m)
+}
+
+// Request sends a message in the direct domain and waits for the response from Nagome.
+// If Nagome rejects the message, the error contains the description.
+func (c *Client) Request(ctx context.Context, com string, ct interface{}) (*viewer.Message, error) {
+	ch := make(chan *viewer.Message, 1)
+	c.mu.Lock()
+	c.waiting[com] = append(c.waiting[com], ch)
+	c.mu.Unlock()
+
+	if err := c.Send(viewer.DomainDirect, com, ct); err != nil {
+		c.cancelWaiting(com, ch)
+		return nil, err
+	}
+
+	select {
+	case m, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("disconnected while waiting for %s", com)
+		}
+		if m.Command == viewer.CommDirectngmError {
+			var e viewer.CtDirectngmError
+			if err := json.Unmarshal(m.Content, &e); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s : %s %v", com, e.Description, e.Errors)
+		}
+		return m, nil
+	case <-ctx.Done():
+		c.cancelWaiting(com, ch)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) cancelWaiting(com string, ch chan *viewer.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ws := c.waiting[com]
+	for i, w := range ws {
+		if w == ch {
+			c.waiting[com] = append(ws[:i], ws[i+1:]...)
+			return
+		}
+	}
+}
+
+// Run receives messages and calls handlers until ctx is done.
+// TCP clients reconnect if the connection is closed.
+func (c *Client) Run(ctx context.Context) error {
+	wait := reconnectMinWait
+	for {
+		err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.mu.Lock()
+		reconnect := c.dial != nil
+		c.mu.Unlock()
+		if !reconnect {
+			return err
+		}
+
+		for {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if err := c.connect(); err == nil {
+				wait = reconnectMinWait
+				break
+			}
+			if wait *= 2; wait > reconnectMaxWait {
+				wait = reconnectMaxWait
+			}
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dial = nil
+	if c.rwc == nil {
+		return nil
+	}
+	return c.rwc.Close()
+}
+
+func (c *Client) serve(ctx context.Context) error {
+	c.mu.Lock()
+	rwc := c.rwc
+	c.mu.Unlock()
+	if rwc == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = rwc.Close()
+		case <-done:
+		}
+	}()
+	defer c.failWaiting()
+
+	dec := json.NewDecoder(rwc)
+	for {
+		m := new(viewer.Message)
+		if err := dec.Decode(m); err != nil {
+			_ = rwc.Close()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		c.dispatch(m)
+	}
+}
+
+func (c *Client) failWaiting() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, ws := range c.waiting {
+		for _, w := range ws {
+			close(w)
+		}
+		delete(c.waiting, k)
+	}
+}
+
+func (c *Client) dispatch(m *viewer.Message) {
+	if m.Domain == viewer.DomainDirectngm && m.Command == viewer.CommDirectngmPing {
+		// Answer pings automatically so Nagome knows the plugin is alive.
+		var ping viewer.CtDirectngmPing
+		if err := json.Unmarshal(m.Content, &ping); err == nil {
+			_ = c.Send(viewer.DomainDirect, viewer.CommDirectPong, viewer.CtDirectPong(ping))
+		}
+		return
+	}
+	if m.Domain == viewer.DomainDirectngm {
+		com := m.Command
+		if com == viewer.CommDirectngmError {
+			var e viewer.CtDirectngmError
+			if err := json.Unmarshal(m.Content, &e); err == nil && e.Domain == viewer.DomainDirect {
+				com = e.Command
+			}
+		}
+		c.mu.Lock()
+		ws := c.waiting[com]
+		if len(ws) != 0 {
+			c.waiting[com] = ws[1:]
+		}
+		c.mu.Unlock()
+		if len(ws) != 0 {
+			ws[0] <- m
+			return
+		}
+	}
+
+	if strings.HasSuffix(m.Domain, viewer.DomainSuffixFilter) {
+		dom := strings.TrimSuffix(m.Domain, viewer.DomainSuffixFilter)
+		c.mu.Lock()
+		f, ok := c.filters[dom]
+		c.mu.Unlock()
+		if !ok {
+			// pass through
+			_ = c.SendMessage(m)
+			return
+		}
+		m.Domain = dom
+		if fm := f(m); fm != nil {
+			fm.Domain = dom + viewer.DomainSuffixFilter
+			_ = c.SendMessage(fm)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	var hs []HandlerFunc
+	hs = append(hs, c.handlers[m.Domain+" "+m.Command]...)
+	hs = append(hs, c.handlers[m.Domain+" "]...)
+	c.mu.Unlock()
+	for _, h := range hs {
+		h(m)
+	}
+}
+
+type stdReadWriteCloser struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (rwc *stdReadWriteCloser) Close() error {
+	errr := rwc.ReadCloser.Close()
+	errw := rwc.WriteCloser.Close()
+	if errr != nil {
+		return errr
+	}
+	return errw
+}
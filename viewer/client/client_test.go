@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/viewer"
+)
+
+func TestClientHandleAndFilter(t *testing.T) {
+	ngm, plg := net.Pipe()
+	c := New(plg, 1)
+
+	got := make(chan *viewer.CtCommentGot, 1)
+	c.OnCommentGot(func(ct *viewer.CtCommentGot) {
+		got <- ct
+	})
+	c.FilterComment(func(ct *viewer.CtCommentGot) bool {
+		ct.Comment = "filtered " + ct.Comment
+		return ct.UserID != "spam"
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = c.Run(ctx)
+	}()
+
+	enc := json.NewEncoder(ngm)
+	dec := json.NewDecoder(ngm)
+
+	err := enc.Encode(viewer.NewMessageMust(viewer.DomainComment, viewer.CommCommentGot,
+		viewer.CtCommentGot{Comment: "test"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ct := <-got:
+		if ct.Comment != "test" {
+			t.Fatalf("Should be %v but %v", "test", ct.Comment)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+
+	// filter
+	go func() {
+		_ = enc.Encode(viewer.NewMessageMust(viewer.DomainComment+viewer.DomainSuffixFilter, viewer.CommCommentGot,
+			viewer.CtCommentGot{Comment: "test", UserID: "spam"}))
+		_ = enc.Encode(viewer.NewMessageMust(viewer.DomainComment+viewer.DomainSuffixFilter, viewer.CommCommentGot,
+			viewer.CtCommentGot{Comment: "test", UserID: "1"}))
+	}()
+	var m viewer.Message
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Domain != viewer.DomainComment+viewer.DomainSuffixFilter {
+		t.Fatalf("Should be %v but %v", viewer.DomainComment+viewer.DomainSuffixFilter, m.Domain)
+	}
+	var ct viewer.CtCommentGot
+	if err := json.Unmarshal(m.Content, &ct); err != nil {
+		t.Fatal(err)
+	}
+	if ct.UserID != "1" || ct.Comment != "filtered test" {
+		t.Fatalf("Unexpected filtered comment %v", ct)
+	}
+}
+
+func TestClientRequest(t *testing.T) {
+	ngm, plg := net.Pipe()
+	c := New(plg, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = c.Run(ctx)
+	}()
+
+	go func() {
+		dec := json.NewDecoder(ngm)
+		enc := json.NewEncoder(ngm)
+		for {
+			var m viewer.Message
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			switch m.Command {
+			case viewer.CommDirectAppVersion:
+				_ = enc.Encode(viewer.NewMessageMust(viewer.DomainDirectngm, viewer.CommDirectngmAppVersion,
+					viewer.CtDirectngmAppVersion{Name: "nagome", Version: "test"}))
+			default:
+				_ = enc.Encode(viewer.NewMessageMust(viewer.DomainDirectngm, viewer.CommDirectngmError,
+					viewer.CtDirectngmError{Domain: m.Domain, Command: m.Command, Description: "invalid"}))
+			}
+		}
+	}()
+
+	v, err := c.AppVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != "test" {
+		t.Fatalf("Should be %v but %v", "test", v.Version)
+	}
+
+	_, err = c.SettingsCurrent(ctx)
+	if err == nil {
+		t.Fatal("Should be failed")
+	}
+
+	tctx, tcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer tcancel()
+	if err := ngm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Request(tctx, "Unknown", nil)
+	if err == nil {
+		t.Fatal("Should be failed")
+	}
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/diginatu/nagome/viewer"
+)
+
+// handleContent registers a handler which decodes the content into the value made by newCt.
+// Messages which have invalid content are logged and ignored.
+func (c *Client) handleContent(dom, com string, newCt func() interface{}, h func(ct interface{})) {
+	c.Handle(dom, com, func(m *viewer.Message) {
+		ct := newCt()
+		if err := json.Unmarshal(m.Content, ct); err != nil {
+			log.Printf("nagome client : invalid content in %v : %s\n", m, err)
+			return
+		}
+		h(ct)
+	})
+}
+
+// OnCommentGot registers a handler for comments (subscribe "nagome_comment").
+func (c *Client) OnCommentGot(h func(ct *viewer.CtCommentGot)) {
+	c.handleContent(viewer.DomainComment, viewer.CommCommentGot,
+		func() interface{} { return new(viewer.CtCommentGot) },
+		func(ct interface{}) { h(ct.(*viewer.CtCommentGot)) })
+}
+
+// OnBroadOpen registers a handler for opening a broadcast (subscribe "nagome").
+func (c *Client) OnBroadOpen(h func(ct *viewer.CtNagomeBroadOpen)) {
+	c.handleContent(viewer.DomainNagome, viewer.CommNagomeBroadOpen,
+		func() interface{} { return new(viewer.CtNagomeBroadOpen) },
+		func(ct interface{}) { h(ct.(*viewer.CtNagomeBroadOpen)) })
+}
+
+// OnBroadClose registers a handler for closing a broadcast (subscribe "nagome").
+func (c *Client) OnBroadClose(h func()) {
+	c.Handle(viewer.DomainNagome, viewer.CommNagomeBroadClose, func(*viewer.Message) { h() })
+}
+
+// OnBroadInfo registers a handler for information of the broadcast (subscribe "nagome").
+func (c *Client) OnBroadInfo(h func(ct *viewer.CtNagomeBroadInfo)) {
+	c.handleContent(viewer.DomainNagome, viewer.CommNagomeBroadInfo,
+		func() interface{} { return new(viewer.CtNagomeBroadInfo) },
+		func(ct interface{}) { h(ct.(*viewer.CtNagomeBroadInfo)) })
+}
+
+// OnUserUpdate registers a handler for updates of user info (subscribe "nagome").
+func (c *Client) OnUserUpdate(h func(ct *viewer.CtNagomeUserUpdate)) {
+	c.handleContent(viewer.DomainNagome, viewer.CommNagomeUserUpdate,
+		func() interface{} { return new(viewer.CtNagomeUserUpdate) },
+		func(ct interface{}) { h(ct.(*viewer.CtNagomeUserUpdate)) })
+}
+
+// OnUserFirst registers a handler for users commenting in the community for the first time (subscribe "nagome").
+func (c *Client) OnUserFirst(h func(ct *viewer.CtNagomeUserFirst)) {
+	c.handleContent(viewer.DomainNagome, viewer.CommNagomeUserFirst,
+		func() interface{} { return new(viewer.CtNagomeUserFirst) },
+		func(ct interface{}) { h(ct.(*viewer.CtNagomeUserFirst)) })
+}
+
+// OnNotification registers a handler for notifications (subscribe "nagome_ui").
+func (c *Client) OnNotification(h func(ct *viewer.CtUINotification)) {
+	c.handleContent(viewer.DomainUI, viewer.CommUINotification,
+		func() interface{} { return new(viewer.CtUINotification) },
+		func(ct interface{}) { h(ct.(*viewer.CtUINotification)) })
+}
+
+// OnAntennaGot registers a handler for antenna items (subscribe "nagome_antenna").
+func (c *Client) OnAntennaGot(h func(ct *viewer.CtAntennaGot)) {
+	c.handleContent(viewer.DomainAntenna, viewer.CommAntennaGot,
+		func() interface{} { return new(viewer.CtAntennaGot) },
+		func(ct interface{}) { h(ct.(*viewer.CtAntennaGot)) })
+}
+
+// OnPlugEnabled registers a handler called when the plugin is enabled or disabled.
+func (c *Client) OnPlugEnabled(h func(enabled bool)) {
+	c.Handle(viewer.DomainDirectngm, viewer.CommDirectngmPlugEnabled, func(*viewer.Message) { h(true) })
+	c.Handle(viewer.DomainDirectngm, viewer.CommDirectngmPlugDisabled, func(*viewer.Message) { h(false) })
+}
+
+// OnError registers a handler for errors about messages sent by the plugin.
+func (c *Client) OnError(h func(ct *viewer.CtDirectngmError)) {
+	c.handleContent(viewer.DomainDirectngm, viewer.CommDirectngmError,
+		func() interface{} { return new(viewer.CtDirectngmError) },
+		func(ct interface{}) { h(ct.(*viewer.CtDirectngmError)) })
+}
+
+// FilterComment registers a filter for comments (subscribe "nagome_comment@filter").
+// Return false to drop the comment.  The comment can be modified.
+func (c *Client) FilterComment(f func(ct *viewer.CtCommentGot) bool) {
+	c.Filter(viewer.DomainComment, func(m *viewer.Message) *viewer.Message {
+		if m.Command != viewer.CommCommentGot {
+			return m
+		}
+		var ct viewer.CtCommentGot
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			log.Printf("nagome client : invalid content in %v : %s\n", m, err)
+			return m
+		}
+		if !f(&ct) {
+			return nil
+		}
+		nm, err := viewer.NewMessage(m.Domain, m.Command, ct)
+		if err != nil {
+			log.Println("nagome client :", err)
+			return m
+		}
+		return nm
+	})
+}
+
+// OnPlugMessage registers a handler for messages from other plugins sent by Plug.Send.
+func (c *Client) OnPlugMessage(h func(ct *viewer.CtDirectngmPlugMessage)) {
+	c.handleContent(viewer.DomainDirectngm, viewer.CommDirectngmPlugMessage,
+		func() interface{} { return new(viewer.CtDirectngmPlugMessage) },
+		func(ct interface{}) { h(ct.(*viewer.CtDirectngmPlugMessage)) })
+}
+
+// SendToPlugin sends a message to the plugin with the name.
+// An Error in nagome_directngm is sent back if the plugin doesn't exist or is disabled.
+func (c *Client) SendToPlugin(name, command string, content interface{}) error {
+	ct := viewer.CtDirectPlugSend{Name: name, Command: command}
+	if content != nil {
+		b, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		ct.Content = b
+	}
+	return c.Send(viewer.DomainDirect, viewer.CommDirectPlugSend, ct)
+}
+
+// OnTimerFired registers a handler for timers set by SetTimer.
+func (c *Client) OnTimerFired(h func(ct *viewer.CtDirectngmTimerFired)) {
+	c.handleContent(viewer.DomainDirectngm, viewer.CommDirectngmTimerFired,
+		func() interface{} { return new(viewer.CtDirectngmTimerFired) },
+		func(ct interface{}) { h(ct.(*viewer.CtDirectngmTimerFired)) })
+}
+
+// SetTimer registers a timer in Nagome.  See viewer.CtDirectTimerSet for the fields.
+func (c *Client) SetTimer(ct viewer.CtDirectTimerSet) error {
+	return c.Send(viewer.DomainDirect, viewer.CommDirectTimerSet, ct)
+}
+
+// CancelTimer cancels the timer.
+func (c *Client) CancelTimer(name string) error {
+	return c.Send(viewer.DomainDirect, viewer.CommDirectTimerCancel, viewer.CtDirectTimerCancel{Name: name})
+}
+
+// LogPrint prints the text using the logger of Nagome.
+func (c *Client) LogPrint(text string) error {
+	return c.Send(viewer.DomainQuery, viewer.CommQueryLogPrint, viewer.CtQueryLogPrint{Text: text})
+}
+
+// SendComment sends a comment to the current broadcast.
+func (c *Client) SendComment(text string, iyayo bool) error {
+	return c.Send(viewer.DomainQuery, viewer.CommQueryBroadSendComment,
+		viewer.CtQueryBroadSendComment{Text: text, Iyayo: iyayo})
+}
+
+// AppVersion requests the name and the version of Nagome.
+func (c *Client) AppVersion(ctx context.Context) (*viewer.CtDirectngmAppVersion, error) {
+	m, err := c.Request(ctx, viewer.CommDirectAppVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+	ct := new(viewer.CtDirectngmAppVersion)
+	return ct, json.Unmarshal(m.Content, ct)
+}
+
+// SettingsCurrent requests the current settings.
+func (c *Client) SettingsCurrent(ctx context.Context) (*viewer.CtDirectngmSettingsCurrent, error) {
+	m, err := c.Request(ctx, viewer.CommDirectSettingsCurrent, nil)
+	if err != nil {
+		return nil, err
+	}
+	ct := new(viewer.CtDirectngmSettingsCurrent)
+	return ct, json.Unmarshal(m.Content, ct)
+}
+
+// State requests the current state of Nagome such as the broadcast and connections.
+func (c *Client) State(ctx context.Context) (*viewer.CtDirectngmStateGet, error) {
+	m, err := c.Request(ctx, viewer.CommDirectStateGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	ct := new(viewer.CtDirectngmStateGet)
+	return ct, json.Unmarshal(m.Content, ct)
+}
@@ -0,0 +1,90 @@
+package viewer
+
+import (
+	"sync"
+)
+
+// A commentBacklog is a ring buffer of recent comments of the current broadcast.
+// Comments are stored after filtering, so replayed comments are same as plugins received.
+type commentBacklog struct {
+	mu   sync.Mutex
+	buf  []CtCommentGot
+	head int // index of the oldest comment
+	n    int
+}
+
+func newCommentBacklog(size int) *commentBacklog {
+	b := new(commentBacklog)
+	b.SetSize(size)
+	return b
+}
+
+// SetSize changes the size of the buffer keeping the newest comments.
+func (b *commentBacklog) SetSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size == len(b.buf) {
+		return
+	}
+	cs := b.list(size)
+	b.buf = make([]CtCommentGot, size)
+	b.head = 0
+	b.n = copy(b.buf, cs)
+}
+
+// Add adds the comment.  The oldest comment is dropped when the buffer is full.
+func (b *commentBacklog) Add(ct CtCommentGot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf) == 0 {
+		return
+	}
+	if b.n < len(b.buf) {
+		b.buf[(b.head+b.n)%len(b.buf)] = ct
+		b.n++
+		return
+	}
+	b.buf[b.head] = ct
+	b.head = (b.head + 1) % len(b.buf)
+}
+
+// Clear drops all comments.
+func (b *commentBacklog) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.head = 0
+	b.n = 0
+}
+
+// List returns the newest limit comments from old to new.  All comments are returned if limit is 0.
+func (b *commentBacklog) List(limit int) []CtCommentGot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.list(limit)
+}
+
+func (b *commentBacklog) list(limit int) []CtCommentGot {
+	n := b.n
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	cs := make([]CtCommentGot, n)
+	for i := range cs {
+		cs[i] = b.buf[(b.head+b.n-n+i)%len(b.buf)]
+	}
+	return cs
+}
+
+// backlogMessages returns the comments as messages marked as backlog.
+func (b *commentBacklog) backlogMessages(limit int) []*Message {
+	cs := b.List(limit)
+	ms := make([]*Message, len(cs))
+	for i, ct := range cs {
+		ct.IsBacklog = true
+		ms[i] = NewMessageMust(DomainComment, CommCommentGot, ct)
+	}
+	return ms
+}
@@ -0,0 +1,64 @@
+package viewer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func backlogNos(cs []CtCommentGot) []int {
+	ns := []int{}
+	for _, c := range cs {
+		ns = append(ns, c.No)
+	}
+	return ns
+}
+
+func TestCommentBacklog(t *testing.T) {
+	b := newCommentBacklog(3)
+	if ns := backlogNos(b.List(0)); len(ns) != 0 {
+		t.Fatalf("Should be empty but %v", ns)
+	}
+	for i := 1; i <= 5; i++ {
+		b.Add(CtCommentGot{No: i})
+	}
+	tests := []struct {
+		limit int
+		want  []int
+	}{
+		{0, []int{3, 4, 5}},
+		{2, []int{4, 5}},
+		{10, []int{3, 4, 5}},
+	}
+	for _, test := range tests {
+		if ns := backlogNos(b.List(test.limit)); !reflect.DeepEqual(ns, test.want) {
+			t.Errorf("limit %d : Should be %v but %v", test.limit, test.want, ns)
+		}
+	}
+
+	b.SetSize(2)
+	if ns := backlogNos(b.List(0)); !reflect.DeepEqual(ns, []int{4, 5}) {
+		t.Fatalf("Should be [4 5] after shrinking but %v", ns)
+	}
+	b.SetSize(4)
+	b.Add(CtCommentGot{No: 6})
+	if ns := backlogNos(b.List(0)); !reflect.DeepEqual(ns, []int{4, 5, 6}) {
+		t.Fatalf("Should be [4 5 6] after growing but %v", ns)
+	}
+
+	for _, m := range b.backlogMessages(1) {
+		if verrs := ValidateContent(m.Domain, m.Command, m.Content); len(verrs) != 0 {
+			t.Fatal(verrs)
+		}
+	}
+
+	b.Clear()
+	if ns := backlogNos(b.List(0)); len(ns) != 0 {
+		t.Fatalf("Should be empty after clearing but %v", ns)
+	}
+
+	b.SetSize(0)
+	b.Add(CtCommentGot{No: 7})
+	if ns := backlogNos(b.List(0)); len(ns) != 0 {
+		t.Fatalf("Should be disabled but %v", ns)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"net"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 
@@ -17,18 +16,27 @@ import (
 
 // A CommentViewer is a pair of an Account and a LiveWaku.
 type CommentViewer struct {
-	Ac       *nicolive.Account
-	Lw       *nicolive.LiveWaku
-	Cmm      *nicolive.CommentConnection
-	Antn     *nicolive.Antenna
-	Pgns     []*Plugin
-	Settings SettingsSlot
-	TCPPort  string
-	Evch     chan *Message
-	quit     chan struct{}
-	wg       sync.WaitGroup
-	prcdnle  *ProceedNicoliveEvent
-	cli      *CLI
+	Ac         *nicolive.Account
+	Lw         *nicolive.LiveWaku
+	Cmm        *nicolive.CommentConnection
+	Antn       *nicolive.Antenna
+	Pgns       []*Plugin
+	pgnsMu     sync.RWMutex // Guards changes of Pgns.  Only the dispatcher changes it after Start, so it reads Pgns without the lock.
+	Settings   SettingsSlot
+	brdInfo    *CtNagomeBroadInfo // Latest information of the current broadcast
+	TCPPort    string
+	Evch       chan *Message
+	quit       chan struct{}
+	quitOnce   sync.Once
+	wg         sync.WaitGroup
+	prcdnle    *ProceedNicoliveEvent
+	plugStrg   *pluginStorage
+	timers     *timerService
+	thumbnails *thumbnailCache
+	backlog    *commentBacklog
+	ng         ngEngine
+	tracer     *tracer
+	cli        *CLI
 }
 
 // NewCommentViewer makes new CommentViewer
@@ -45,7 +53,25 @@ func NewCommentViewer(tcpPort string, cli *CLI) *CommentViewer {
 		quit:     make(chan struct{}),
 		cli:      cli,
 	}
+	cv.timers = newTimerService(cv)
 	cv.prcdnle = NewProceedNicoliveEvent(cv)
+	cv.thumbnails = newThumbnailCache(filepath.Join(cli.SavePath, thumbnailDirName), cv.Settings.Thumbnail,
+		cv.quit, &cv.wg, cv.emitThumbnailUpdate, cli.log)
+	cv.backlog = newCommentBacklog(cv.Settings.CommentBacklogSize)
+	if err := cv.ng.Set(&cv.Settings.NG); err != nil {
+		cli.log.Println(err)
+	}
+	if cli.MemoryStorage {
+		cv.plugStrg = newMemoryPluginStorage()
+	} else {
+		strg, err := newPluginStorage(filepath.Join(cli.SavePath, plugDataDirName))
+		if err != nil {
+			// e.g. locked by another instance
+			cli.log.Println("plugin storage is kept in memory : " + err.Error())
+			strg = newMemoryPluginStorage()
+		}
+		cv.plugStrg = strg
+	}
 	return cv
 }
 
@@ -56,6 +82,10 @@ func (cv *CommentViewer) Start() {
 	cv.wg.Add(2)
 	go cv.pluginTCPServer(waitWakeServer)
 	go cv.sendNagomeMessage()
+	if port := cv.Settings.Thumbnail.HTTPPort; port != "" {
+		cv.wg.Add(1)
+		go cv.thumbnailHTTPServer(port)
+	}
 
 	<-waitWakeServer
 	cv.loadPlugins()
@@ -73,14 +103,26 @@ func (cv *CommentViewer) AntennaConnect() {
 }
 
 // Wait waits for quiting after Start().
+// The storages are closed after all goroutines using them finish.
 func (cv *CommentViewer) Wait() {
-	defer cv.AntennaDisconnect()
-	defer cv.Disconnect()
 	cv.wg.Wait()
+	cv.Disconnect()
+	cv.AntennaDisconnect()
+	if err := cv.prcdnle.userDB.Close(); err != nil {
+		cv.cli.log.Println(err)
+	}
+	if err := cv.plugStrg.Close(); err != nil {
+		cv.cli.log.Println(err)
+	}
+	if err := cv.tracer.Close(); err != nil {
+		cv.cli.log.Println(err)
+	}
 }
 
 // Plugin returns plugin with given No.
 func (cv *CommentViewer) Plugin(n int) (*Plugin, error) {
+	cv.pgnsMu.RLock()
+	defer cv.pgnsMu.RUnlock()
 	if n < 0 || len(cv.Pgns) <= n {
 		return nil, fmt.Errorf("invalid plugin No")
 	}
@@ -89,6 +131,8 @@ func (cv *CommentViewer) Plugin(n int) (*Plugin, error) {
 
 // PluginName returns name of the plugin with given No.
 func (cv *CommentViewer) PluginName(n int) string {
+	cv.pgnsMu.RLock()
+	defer cv.pgnsMu.RUnlock()
 	if n < -1 || len(cv.Pgns) <= n {
 		cv.cli.log.Printf("invalid plugin num : %d\n", n)
 		return "???"
@@ -99,8 +143,34 @@ func (cv *CommentViewer) PluginName(n int) string {
 	return cv.Pgns[n].Name
 }
 
+// findPlugin returns the plugin with given name or No.
+// If both are given, they have to point to the same plugin.
+func (cv *CommentViewer) findPlugin(name string, no *int) (*Plugin, error) {
+	if no != nil {
+		p, err := cv.Plugin(*no)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" && p.Name != name {
+			return nil, fmt.Errorf("plugin No %d is not [%s]", *no, name)
+		}
+		return p, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("neither name nor No of the plugin is specified")
+	}
+	for _, p := range cv.Pgns {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no plugin named %s", name)
+}
+
 // AddPlugin adds new plugin to Pgns
 func (cv *CommentViewer) AddPlugin(p *Plugin) {
+	cv.pgnsMu.Lock()
+	defer cv.pgnsMu.Unlock()
 	p.No = len(cv.Pgns)
 	cv.Pgns = append(cv.Pgns, p)
 }
@@ -114,47 +184,94 @@ func (cv *CommentViewer) loadPlugins() {
 		return
 	}
 
+	loaded := make(map[string]string)
 	for _, d := range ds {
-		if d.IsDir() {
+		if d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
 			p := newPlugin(cv)
 			pPath := filepath.Join(psPath, d.Name())
-			err = p.Load(filepath.Join(pPath, "plugin.yml"))
+			err = p.loadNormal(filepath.Join(pPath, pluginConfigName))
 			if err != nil {
 				cv.cli.log.Println("failed load plugin : ", d.Name())
 				cv.cli.log.Println(err)
 				continue
 			}
+			if dir, ok := loaded[p.Name]; ok {
+				err := &PluginConflictError{p.Name, []string{dir, d.Name()}}
+				cv.cli.log.Println(err)
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Plugin conflict", err.Error())
+				continue
+			}
+			loaded[p.Name] = d.Name()
 
+			if err := cv.loadGrantedPermissions(p); err != nil {
+				cv.cli.log.Println(err)
+			}
 			cv.AddPlugin(p)
+			cv.requestPermissions(p)
 
-			for i := range p.Exec {
-				p.Exec[i] = strings.Replace(p.Exec[i], "{{path}}", pPath, -1)
-				p.Exec[i] = strings.Replace(p.Exec[i], "{{port}}", cv.TCPPort, -1)
-				p.Exec[i] = strings.Replace(p.Exec[i], "{{no}}", strconv.Itoa(p.No), -1)
-			}
+			p.dir = pPath
+			p.expandExec(pPath, cv.TCPPort)
+			cv.startPlugin(p)
+		}
+	}
 
-			switch p.Method {
-			case pluginMethodTCP:
-				if len(p.Exec) >= 1 {
-					cmd := exec.Command(p.Exec[0], p.Exec[1:]...)
-					cmd.Dir = pPath
-					err := cmd.Start()
-					if err != nil {
-						cv.cli.log.Println(err)
-						continue
-					}
-				}
-			case pluginMethodStd:
-				cv.wg.Add(1)
-				go handleSTDPlugin(p, cv, pPath)
-			default:
-				cv.cli.log.Printf("invalid method in plugin [%s]\n", p.Name)
-				continue
+	cv.warnUndeclaredDomains()
+}
+
+// startPlugin launches the process of the normal plugin.
+func (cv *CommentViewer) startPlugin(p *Plugin) {
+	switch p.Method {
+	case pluginMethodTCP:
+		if len(p.Exec) >= 1 {
+			cmd := exec.Command(p.Exec[0], p.Exec[1:]...)
+			cmd.Dir = p.dir
+			err := cmd.Start()
+			if err != nil {
+				cv.cli.log.Println(err)
+				return
 			}
+			p.cmd = cmd
 		}
+	case pluginMethodStd:
+		cv.wg.Add(1)
+		go handleSTDPlugin(p, cv, p.dir)
+	default:
+		cv.cli.log.Printf("invalid method in plugin [%s]\n", p.Name)
 	}
 }
 
+// restartPlugin disconnects the normal plugin and launches it again as a new Plugin with the same No.
+func (cv *CommentViewer) restartPlugin(old *Plugin) error {
+	if old.IsMain() || old.dir == "" {
+		return fmt.Errorf("plugin [%s] is not launched by Nagome", old.Name)
+	}
+	old.disconnect()
+
+	p := newPlugin(cv)
+	p.Name = old.Name
+	p.Description = old.Description
+	p.Version = old.Version
+	p.Author = old.Author
+	p.Method = old.Method
+	p.Exec = old.Exec
+	p.Nagomever = old.Nagomever
+	p.Subscribe = old.Subscribe
+	p.Domains = old.Domains
+	p.Backlog = old.Backlog
+	p.Ping = old.Ping
+	p.Settings = old.Settings
+	p.Permissions = old.Permissions
+	p.Granted = old.Granted
+	p.No = old.No
+	p.dir = old.dir
+	cv.pgnsMu.Lock()
+	cv.Pgns[p.No] = p
+	cv.pgnsMu.Unlock()
+
+	cv.startPlugin(p)
+	return nil
+}
+
 func (cv *CommentViewer) pluginTCPServer(waitWakeServer chan struct{}) {
 	defer cv.wg.Done()
 
@@ -213,12 +330,55 @@ func (cv *CommentViewer) sendNagomeMessage() {
 	readLoop:
 		select {
 		case mes := <-cv.Evch:
+			cv.trace(TraceEventIn, mes, nil, "")
+
+			// Internal messages to a plugin
+			if mes.to != nil {
+				if fail := mes.to.WriteMess(mes); !fail {
+					cv.trace(TraceEventOut, mes, []string{mes.to.Name}, "")
+				}
+				continue
+			}
+
+			// Validate the content of messages from plugins
+			if mes.plgno >= 0 {
+				if verrs := ValidateContent(mes.Domain, mes.Command, mes.Content); len(verrs) != 0 {
+					cv.cli.log.Printf("invalid content in the message form [%s] : %v\n", cv.PluginName(mes.plgno), verrs)
+					cv.rejectMessage(mes, "invalid content", verrs)
+					continue
+				}
+			}
+
+			if err := cv.checkCustomDomain(mes); err != nil {
+				cv.cli.log.Println(err)
+				cv.rejectMessage(mes, err.Error(), nil)
+				continue
+			}
+
+			// Check the permission of the origin before the message reaches other plugins.
+			if err := cv.restoreFilterOrigin(mes); err != nil {
+				cv.cli.log.Println(err)
+				cv.rejectMessage(mes, err.Error(), nil)
+				continue
+			}
+			if err := cv.checkPermission(mes); err != nil {
+				cv.cli.log.Println(err)
+				cv.rejectMessage(mes, err.Error(), nil)
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Permission", err.Error())
+				continue
+			}
+
 			// Direct
 			if mes.Domain == DomainDirect {
 				nicoerr := processDirectMessage(cv, mes)
 				if nicoerr != nil {
 					cv.cli.log.Printf("plugin message error form [%s] : %s\n", cv.PluginName(mes.plgno), nicoerr)
 					cv.cli.log.Println(mes)
+					desc := nicoerr.Error()
+					if nerr, ok := nicoerr.(nicolive.Error); ok {
+						desc = nerr.Description()
+					}
+					cv.rejectMessage(mes, desc, nil)
 				}
 				continue
 			}
@@ -232,14 +392,17 @@ func (cv *CommentViewer) sendNagomeMessage() {
 				mes.Domain = strings.TrimSuffix(mes.Domain, DomainSuffixFilter)
 			}
 			for i := st; i < len(cv.Pgns); i++ {
-				if cv.Pgns[i].IsSubscribe(mes.Domain + DomainSuffixFilter) {
+				if cv.Pgns[i].IsSubscribe(mes.Domain+DomainSuffixFilter) && cv.Pgns[i].canFilter(mes.Domain) {
 					// Add suffix to a message for filter plugin.
 					tmes := *mes
 					tmes.Domain = mes.Domain + DomainSuffixFilter
+					tmes.Token = cv.Pgns[i].pushFilterOrigin(mes.origin)
 					fail := cv.Pgns[i].WriteMess(&tmes)
 					if fail {
+						cv.Pgns[i].popFilterOrigin(tmes.Token)
 						continue
 					}
+					cv.trace(TraceEventFilter, &tmes, []string{cv.Pgns[i].Name}, "")
 					break readLoop
 				}
 			}
@@ -252,11 +415,15 @@ func (cv *CommentViewer) sendNagomeMessage() {
 			}
 
 			// regular
+			var to []string
 			for i := range cv.Pgns {
 				if cv.Pgns[i].IsSubscribe(mes.Domain) {
-					cv.Pgns[i].Write(jmes)
+					if fail := cv.Pgns[i].Write(jmes); !fail {
+						to = append(to, cv.Pgns[i].Name)
+					}
 				}
 			}
+			cv.trace(TraceEventOut, mes, to, "")
 
 			nerr := processNagomeMessage(cv, mes)
 			if nerr != nil {
@@ -280,10 +447,73 @@ func (cv *CommentViewer) sendNagomeMessage() {
 	}
 }
 
+// restoreFilterOrigin sets the origin of the message which a filter plugin sent back with the token.
+// A message without a token is originated by the plugin.
+func (cv *CommentViewer) restoreFilterOrigin(m *Message) error {
+	tok := m.Token
+	m.Token = ""
+	if m.plgno < 0 || tok == "" {
+		return nil
+	}
+	if !strings.HasSuffix(m.Domain, DomainSuffixFilter) {
+		return fmt.Errorf("token in the message which is not filtered")
+	}
+	pl, err := cv.Plugin(m.plgno)
+	if err != nil {
+		return err
+	}
+	o, ok := pl.popFilterOrigin(tok)
+	if !ok {
+		return fmt.Errorf("unknown token of the filtered message")
+	}
+	m.origin = o
+	return nil
+}
+
+// rejectMessage sends an error message about the given message back to the plugin that sent it.
+func (cv *CommentViewer) rejectMessage(m *Message, desc string, verrs []ValidationError) {
+	cv.trace(TraceEventReject, m, nil, desc)
+	p, err := cv.Plugin(m.plgno)
+	if err != nil {
+		return
+	}
+	p.WriteMess(NewMessageMust(DomainDirectngm, CommDirectngmError, CtDirectngmError{
+		Domain:      m.Domain,
+		Command:     m.Command,
+		Description: desc,
+		Errors:      verrs,
+	}))
+}
+
 // EmitEvNewNotification emits new event for ask UI to display a notification.
 func (cv *CommentViewer) EmitEvNewNotification(typ, title, desc string) {
 	cv.cli.log.Printf("[D] %s : %s", title, desc)
-	cv.Evch <- NewMessageMust(DomainUI, CommUINotification, CtUINotification{typ, title, desc})
+	cv.emit(NewMessageMust(DomainUI, CommUINotification, CtUINotification{typ, title, desc}))
+}
+
+// emit sends the message to the dispatcher.
+// It is dropped after quitting, since nobody receives it.
+func (cv *CommentViewer) emit(m *Message) {
+	select {
+	case cv.Evch <- m:
+	case <-cv.quit:
+	}
+}
+
+// state returns the snapshot of the current state for late-joining plugins.
+func (cv *CommentViewer) state() CtDirectngmStateGet {
+	st := CtDirectngmStateGet{
+		BroadInfo: cv.brdInfo,
+		Connected: cv.Cmm != nil,
+		Antenna:   cv.Antn != nil,
+		LoggedIn:  cv.Ac != nil && cv.Ac.Usersession != "",
+	}
+	if cv.Lw != nil {
+		b := newCtNagomeBroadOpen(cv.Lw)
+		st.Broad = &b
+		st.User = &CtStateUser{cv.Lw.User.UserID, cv.Lw.User.Name, cv.Lw.User.IsPremium}
+	}
+	return st
 }
 
 // Disconnect disconnects current comment connection if connected.
@@ -298,6 +528,8 @@ func (cv *CommentViewer) Disconnect() {
 	}
 	cv.Cmm = nil
 	cv.Lw = nil
+	cv.brdInfo = nil
+	cv.backlog.Clear()
 }
 
 // AntennaDisconnect disconnects current antenna connection if connected.
@@ -314,13 +546,29 @@ func (cv *CommentViewer) AntennaDisconnect() {
 }
 
 // Quit quits the CommentViewer.
+// It is safe to call more than once.
 func (cv *CommentViewer) Quit() {
 	cv.wg.Add(1)
 	defer cv.wg.Done()
 
-	close(cv.quit)
-	err := cv.prcdnle.userDB.Close()
+	cv.quitOnce.Do(func() {
+		close(cv.quit)
+		cv.timers.CancelAll()
+	})
+}
+
+// NicoliveEventReceiver returns the receiver which converts events from nicolive package into Nagome messages.
+func (cv *CommentViewer) NicoliveEventReceiver() nicolive.EventReceiver {
+	return cv.prcdnle
+}
+
+// EnableTrace starts recording messages in the dispatcher to the file.
+// It also accepts injecting traces from TCP connections.
+func (cv *CommentViewer) EnableTrace(path string) error {
+	t, err := newTracer(path)
 	if err != nil {
-		cv.cli.log.Println(err)
+		return err
 	}
+	cv.tracer = t
+	return nil
 }
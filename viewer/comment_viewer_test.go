@@ -0,0 +1,63 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestCommentViewerState(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	st := cv.state()
+	if st.Broad != nil || st.User != nil || st.Connected || st.Antenna || st.LoggedIn {
+		t.Fatalf("Should be empty state but %#v", st)
+	}
+
+	cv.Ac = &nicolive.Account{Usersession: "session"}
+	cv.Lw = &nicolive.LiveWaku{BroadID: "lv1"}
+	cv.Lw.Stream.Title = "title"
+	cv.Lw.User.UserID = "100"
+	m := NewMessageMust(DomainNagome, CommNagomeBroadInfo, CtNagomeBroadInfo{"10", "20"})
+	if err := processNagomeMessage(cv, m); err != nil {
+		t.Fatal(err)
+	}
+
+	st = cv.state()
+	if !st.LoggedIn || st.Broad == nil || st.Broad.BroadID != "lv1" || st.Broad.Title != "title" ||
+		st.User == nil || st.User.UserID != "100" || st.BroadInfo == nil || st.BroadInfo.WatchCount != "10" {
+		t.Fatalf("Unexpected state %#v", st)
+	}
+	if verrs := ValidateContent(DomainDirectngm, CommDirectngmStateGet, mustMarshal(t, st)); len(verrs) != 0 {
+		t.Fatal(verrs)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
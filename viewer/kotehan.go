@@ -0,0 +1,63 @@
+package viewer
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+// Scopes of user names learned from comments (kotehan).
+const (
+	KotehanScopeGlobal    = "global"    // The name is used in all communities.
+	KotehanScopeCommunity = "community" // The name is used only in the community of the broadcast.
+)
+
+const kotehanMaxLen = 32
+
+var kotehanRegex = regexp.MustCompile(`[@＠]([^@＠\s]+)\s*$`)
+
+// parseKotehan returns the name in "@name" or "＠name" at the end of the comment.
+// Numbers are not names because they are used as anchors to comment numbers.
+func parseKotehan(comment string) string {
+	sm := kotehanRegex.FindStringSubmatch(comment)
+	if sm == nil {
+		return ""
+	}
+	name := sm[1]
+	if utf8.RuneCountInString(name) > kotehanMaxLen {
+		return ""
+	}
+	for _, c := range name {
+		if !unicode.IsDigit(c) {
+			return name
+		}
+	}
+	return ""
+}
+
+// learnKotehan sets the name in the comment to the user.
+// It returns whether the user is changed.
+func (p *ProceedNicoliveEvent) learnKotehan(cm *nicolive.Comment, u *nicolive.User) bool {
+	name := parseKotehan(cm.Comment)
+	if name == "" {
+		return false
+	}
+
+	if p.cv.Settings.KotehanScope == KotehanScopeCommunity && p.communityID != "" {
+		if u.NameIn(p.communityID) == name {
+			return false
+		}
+		if u.CommunityNames == nil {
+			u.CommunityNames = make(map[string]string)
+		}
+		u.CommunityNames[p.communityID] = name
+	} else {
+		if u.Name == name {
+			return false
+		}
+		u.Name = name
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestParseKotehan(t *testing.T) {
+	tests := []struct {
+		comment, name string
+	}{
+		{"hello", ""},
+		{"hello@name", "name"},
+		{"hello ＠なまえ ", "なまえ"},
+		{"@name hello", ""},
+		{"@", ""},
+		{">>1 @10", ""},
+		{"@１０", ""},
+		{"@a@b", "b"},
+		{"@" + strings.Repeat("a", kotehanMaxLen+1), ""},
+	}
+	for _, test := range tests {
+		if n := parseKotehan(test.comment); n != test.name {
+			t.Errorf("%q : expected %q but %q", test.comment, test.name, n)
+		}
+	}
+}
+
+func TestKotehanLearn(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	comment := func(id, c string) {
+		cv.prcdnle.ProceedNicoEvent(&nicolive.Event{
+			Type:    nicolive.EventTypeCommentGot,
+			Content: nicolive.Comment{UserID: id, Comment: c},
+		})
+	}
+	next := func(dom, com string, v interface{}) {
+		select {
+		case m := <-cv.Evch:
+			if m.Domain == DomainNagome && m.Command == CommNagomeUserFirst {
+				m = <-cv.Evch
+			}
+			if m.Domain != dom || m.Command != com {
+				t.Fatalf("expected %s %s but %v", dom, com, m)
+			}
+			if err := json.Unmarshal(m.Content, v); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("expected %s %s but nothing", dom, com)
+		}
+	}
+	var ct CtCommentGot
+	var uu CtNagomeUserUpdate
+
+	comment("abc", "hello@name1")
+	next(DomainComment, CommCommentGot, &ct)
+	next(DomainNagome, CommNagomeUserUpdate, &uu)
+	if ct.UserName != "name1" || uu.ID != "abc" || uu.Name != "name1" || !uu.Is184 {
+		t.Fatalf("Unexpected %#v %#v", ct, uu)
+	}
+
+	// Same name doesn't emit User.Update
+	comment("abc", "@name1")
+	comment("abc", "hello")
+	next(DomainComment, CommCommentGot, &ct)
+	next(DomainComment, CommCommentGot, &ct)
+	if ct.UserName != "name1" || len(cv.Evch) != 0 {
+		t.Fatalf("Unexpected %#v", ct)
+	}
+
+	cv.Settings.KotehanScope = KotehanScopeCommunity
+	cv.prcdnle.communityID = "co1"
+	comment("abc", "@name2")
+	next(DomainComment, CommCommentGot, &ct)
+	next(DomainNagome, CommNagomeUserUpdate, &uu)
+	if ct.UserName != "name2" || uu.Name != "name1" || uu.CommunityNames["co1"] != "name2" {
+		t.Fatalf("Unexpected %#v %#v", ct, uu)
+	}
+	cv.prcdnle.communityID = "co2"
+	comment("abc", "hello")
+	next(DomainComment, CommCommentGot, &ct)
+	if ct.UserName != "name1" {
+		t.Fatalf("Unexpected %#v", ct)
+	}
+
+	cv.Settings.KotehanLearn = false
+	comment("abc", "@name3")
+	next(DomainComment, CommCommentGot, &ct)
+	if ct.UserName != "name1" || len(cv.Evch) != 0 {
+		t.Fatalf("Unexpected %#v", ct)
+	}
+}
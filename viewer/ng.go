@@ -0,0 +1,193 @@
+package viewer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+// Types of NG rules.
+// They are used in NG.Add and NG.Remove, and set to "ng" field of marked comments.
+const (
+	NGTypeWord   = "word"
+	NGTypeRegexp = "regexp"
+	NGTypeUser   = "user"
+	NGType184    = "184"
+	NGTypeScore  = "score"
+)
+
+// ngFilter is a compiled NGSettings.
+type ngFilter struct {
+	words    []string
+	regexps  []*regexp.Regexp
+	users    map[string]bool
+	block184 bool
+	minScore int
+	mark     bool
+}
+
+// newNGFilter compiles s.
+// Invalid regular expressions are skipped and reported as the error.
+func newNGFilter(s *NGSettings) (*ngFilter, error) {
+	f := &ngFilter{
+		users:    make(map[string]bool),
+		block184: s.Block184,
+		minScore: s.MinScore,
+		mark:     s.Mark,
+	}
+	for _, w := range s.Words {
+		if w != "" {
+			f.words = append(f.words, w)
+		}
+	}
+	var errs []string
+	for _, r := range s.Regexps {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		f.regexps = append(f.regexps, re)
+	}
+	for _, u := range s.Users {
+		f.users[u] = true
+	}
+	if len(errs) != 0 {
+		return f, fmt.Errorf("invalid NG regexp : %s", strings.Join(errs, ", "))
+	}
+	return f, nil
+}
+
+// match returns the type of the first NG rule which cm matches, or empty string.
+// Command comments (by the broadcaster or the system) are never NG.
+func (f *ngFilter) match(cm *nicolive.Comment) string {
+	if cm.IsCommand {
+		return ""
+	}
+	if f.users[cm.UserID] {
+		return NGTypeUser
+	}
+	if f.block184 && cm.IsAnonymity {
+		return NGType184
+	}
+	if f.minScore != 0 && cm.Score < f.minScore {
+		return NGTypeScore
+	}
+	for _, w := range f.words {
+		if strings.Contains(cm.Comment, w) {
+			return NGTypeWord
+		}
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(cm.Comment) {
+			return NGTypeRegexp
+		}
+	}
+	return ""
+}
+
+// ngEngine holds the current ngFilter.
+// It is used from both of the dispatcher and connections of nicolive.
+type ngEngine struct {
+	mu sync.RWMutex
+	f  *ngFilter
+}
+
+// Set replaces the filter with the one compiled from s.
+func (e *ngEngine) Set(s *NGSettings) error {
+	f, err := newNGFilter(s)
+	e.mu.Lock()
+	e.f = f
+	e.mu.Unlock()
+	return err
+}
+
+// Match returns the type of NG rule which cm matches and whether the comment should be dropped.
+func (e *ngEngine) Match(cm *nicolive.Comment) (typ string, drop bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.f == nil {
+		return "", false
+	}
+	typ = e.f.match(cm)
+	return typ, typ != "" && !e.f.mark
+}
+
+// addNG adds a rule to s.
+// For NGType184, it enables blocking.  For NGTypeScore, value is the minimum score.
+func addNG(s *NGSettings, typ, value string) error {
+	switch typ {
+	case NGTypeWord:
+		if value == "" {
+			return fmt.Errorf("empty NG word")
+		}
+		s.Words = appendUniq(s.Words, value)
+	case NGTypeRegexp:
+		if _, err := regexp.Compile(value); err != nil {
+			return err
+		}
+		s.Regexps = appendUniq(s.Regexps, value)
+	case NGTypeUser:
+		if value == "" {
+			return fmt.Errorf("empty NG user ID")
+		}
+		s.Users = appendUniq(s.Users, value)
+	case NGType184:
+		s.Block184 = true
+	case NGTypeScore:
+		sc, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid NG score : %s", value)
+		}
+		s.MinScore = sc
+	default:
+		return fmt.Errorf("unknown NG type : %s", typ)
+	}
+	return nil
+}
+
+// removeNG removes a rule from s.
+// For NGType184 and NGTypeScore, value is ignored and the rule is disabled.
+func removeNG(s *NGSettings, typ, value string) error {
+	var ok bool
+	switch typ {
+	case NGTypeWord:
+		s.Words, ok = removeString(s.Words, value)
+	case NGTypeRegexp:
+		s.Regexps, ok = removeString(s.Regexps, value)
+	case NGTypeUser:
+		s.Users, ok = removeString(s.Users, value)
+	case NGType184:
+		s.Block184, ok = false, true
+	case NGTypeScore:
+		s.MinScore, ok = 0, true
+	default:
+		return fmt.Errorf("unknown NG type : %s", typ)
+	}
+	if !ok {
+		return fmt.Errorf("no NG %s : %s", typ, value)
+	}
+	return nil
+}
+
+func appendUniq(ss []string, s string) []string {
+	for _, c := range ss {
+		if c == s {
+			return ss
+		}
+	}
+	return append(ss, s)
+}
+
+func removeString(ss []string, s string) ([]string, bool) {
+	for i, c := range ss {
+		if c == s {
+			return append(ss[:i:i], ss[i+1:]...), true
+		}
+	}
+	return ss, false
+}
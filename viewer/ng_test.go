@@ -0,0 +1,162 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestNGFilterMatch(t *testing.T) {
+	s := &NGSettings{
+		Words:    []string{"spam", ""},
+		Regexps:  []string{"^w+$", "("},
+		Users:    []string{"100"},
+		Block184: true,
+		MinScore: -1000,
+	}
+	f, err := newNGFilter(s)
+	if err == nil {
+		t.Fatal("Should fail for the invalid regexp")
+	}
+
+	tests := []struct {
+		cm  nicolive.Comment
+		typ string
+	}{
+		{nicolive.Comment{UserID: "1", Comment: "hello"}, ""},
+		{nicolive.Comment{UserID: "1", Comment: "this is spam"}, NGTypeWord},
+		{nicolive.Comment{UserID: "1", Comment: "www"}, NGTypeRegexp},
+		{nicolive.Comment{UserID: "1", Comment: "awww"}, ""},
+		{nicolive.Comment{UserID: "100", Comment: "hello"}, NGTypeUser},
+		{nicolive.Comment{UserID: "abc", Comment: "hello", IsAnonymity: true}, NGType184},
+		{nicolive.Comment{UserID: "1", Comment: "hello", Score: -1000}, ""},
+		{nicolive.Comment{UserID: "1", Comment: "hello", Score: -1001}, NGTypeScore},
+		{nicolive.Comment{UserID: "100", Comment: "spam", IsCommand: true}, ""},
+	}
+	for i, test := range tests {
+		if typ := f.match(&test.cm); typ != test.typ {
+			t.Errorf("%d : expected %q but %q", i, test.typ, typ)
+		}
+	}
+}
+
+func TestNGAddRemove(t *testing.T) {
+	var s NGSettings
+	for _, a := range []struct{ typ, value string }{
+		{NGTypeWord, "a"}, {NGTypeWord, "a"}, {NGTypeWord, "b"},
+		{NGTypeRegexp, "c+"}, {NGTypeUser, "1"}, {NGType184, ""}, {NGTypeScore, "-500"},
+	} {
+		if err := addNG(&s, a.typ, a.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := NGSettings{
+		Words:    []string{"a", "b"},
+		Regexps:  []string{"c+"},
+		Users:    []string{"1"},
+		Block184: true,
+		MinScore: -500,
+	}
+	if !reflect.DeepEqual(s, exp) {
+		t.Fatalf("expected %#v but %#v", exp, s)
+	}
+
+	for _, a := range []struct{ typ, value string }{
+		{NGTypeWord, ""}, {NGTypeRegexp, "("}, {NGTypeScore, "a"}, {"unknown", "a"},
+	} {
+		if err := addNG(&s, a.typ, a.value); err == nil {
+			t.Errorf("Should fail to add %v", a)
+		}
+	}
+
+	if err := removeNG(&s, NGTypeWord, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeNG(&s, NGTypeWord, "a"); err == nil {
+		t.Fatal("Should fail to remove not existing word")
+	}
+	if err := removeNG(&s, NGType184, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Words, []string{"b"}) || s.Block184 {
+		t.Fatalf("Unexpected settings %#v", s)
+	}
+}
+
+func TestNGComment(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	m := NewMessageMust(DomainQuery, CommQueryNGAdd, CtQueryNGAdd{Type: NGTypeWord, Value: "spam"})
+	if err := processNagomeMessage(cv, m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cv.Settings.NG.Words, []string{"spam"}) {
+		t.Fatalf("Not added to the settings : %#v", cv.Settings.NG)
+	}
+
+	comment := func(no int, c string) *nicolive.Event {
+		return &nicolive.Event{
+			Type:    nicolive.EventTypeCommentGot,
+			Content: nicolive.Comment{No: no, UserID: "1", Comment: c},
+		}
+	}
+	got := func() CtCommentGot {
+		var ct CtCommentGot
+		select {
+		case m := <-cv.Evch:
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatal("No comment is emitted")
+		}
+		return ct
+	}
+
+	cv.prcdnle.ProceedNicoEvent(comment(1, "spam"))
+	cv.prcdnle.ProceedNicoEvent(comment(2, "hello"))
+	if ct := got(); ct.No != 2 || ct.NG != "" {
+		t.Fatalf("Unexpected comment %#v", ct)
+	}
+
+	ct := CtQuerySettingsSetCurrent(cv.Settings.Duplicate())
+	ct.NG.Mark = true
+	if err := processNagomeMessage(cv, NewMessageMust(DomainQuery, CommQuerySettingsSetCurrent, ct)); err != nil {
+		t.Fatal(err)
+	}
+	cv.prcdnle.ProceedNicoEvent(comment(3, "spam"))
+	if ct := got(); ct.No != 3 || ct.NG != NGTypeWord {
+		t.Fatalf("Unexpected comment %#v", ct)
+	}
+
+	m = NewMessageMust(DomainQuery, CommQueryNGRemove, CtQueryNGRemove{Type: NGTypeWord, Value: "spam"})
+	if err := processNagomeMessage(cv, m); err != nil {
+		t.Fatal(err)
+	}
+	cv.prcdnle.ProceedNicoEvent(comment(4, "spam"))
+	if ct := got(); ct.No != 4 || ct.NG != "" {
+		t.Fatalf("Unexpected comment %#v", ct)
+	}
+}
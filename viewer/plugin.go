@@ -2,12 +2,17 @@ package viewer
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -19,6 +24,9 @@ const (
 	pluginMethodTCP           = "tcp"
 	pluginMethodStd           = "std"
 	pluginEachMessageChanSize = 3
+	pluginHandshakeDu         = 30 * time.Second // Time limit to receive Direct.No from a TCP plugin
+	pluginFilterOriginsMax    = 100              // Origins of filtered messages kept for each plugin
+	pluginStopTimeout         = 5 * time.Second  // Time to wait for a plugin to stop before uninstalling it
 )
 
 type pluginState int
@@ -31,27 +39,84 @@ const (
 
 // A Plugin is a Nagome plugin.
 type Plugin struct {
-	Name        string      `yaml:"name"        json:"name"`
-	Description string      `yaml:"description" json:"description"`
-	Version     string      `yaml:"version"     json:"version"`
-	Author      string      `yaml:"author"      json:"author"`
-	Method      string      `yaml:"method"      json:"method"`
-	Exec        []string    `yaml:"exec"        json:"-"`
-	Nagomever   string      `yaml:"nagomever"   json:"-"`
-	Subscribe   []string    `yaml:"subscribe"   json:"subscribe"`
-	No          int         `yaml:"-"           json:"no"`
-	GetState    pluginState `yaml:"-"           json:"state"` // Don't change directly
+	Name        string          `yaml:"name"        json:"name"`
+	Description string          `yaml:"description" json:"description"`
+	Version     string          `yaml:"version"     json:"version"`
+	Author      string          `yaml:"author"      json:"author"`
+	Method      string          `yaml:"method"      json:"method"`
+	Exec        []string        `yaml:"exec"        json:"-"`
+	Nagomever   string          `yaml:"nagomever"   json:"-"`
+	Subscribe   []string        `yaml:"subscribe"   json:"subscribe"`
+	Domains     []string        `yaml:"domains"     json:"domains,omitempty"` // Custom domains which the plugin sends
+	Backlog     bool            `yaml:"backlog"     json:"backlog"`           // Replay recent comments when enabled
+	Ping        bool            `yaml:"ping"        json:"-"`                 // Answers pings.  Monitored before the first answer.
+	Settings    []PluginSetting `yaml:"settings"    json:"settings,omitempty"`
+	Permissions []string        `yaml:"permissions" json:"permissions,omitempty"`
+	Granted     []string        `yaml:"-"           json:"granted,omitempty"` // Permissions granted by the user
+	No          int             `yaml:"-"           json:"no"`
+	GetState    pluginState     `yaml:"-"           json:"state"`          // Don't change directly
+	PingState   string          `yaml:"-"           json:"ping,omitempty"` // "ok" or "unresponsive" if the plugin answers pings
 	setStateCh  chan (pluginState)
 	stateMu     sync.Mutex
+	enabled     int32 // 1 while GetState is pluginStateEnable.  Read atomically without stateMu, which Write holds while blocking.
 	rwc         io.ReadWriteCloser
 	flushTm     *time.Timer
 	wg          sync.WaitGroup
 	cv          *CommentViewer
 	quit        chan (struct{})
 	writec      chan ([]byte)
+	ping        pluginPing
+	pingMu      sync.Mutex
+	dir         string         // Plugin directory.  Empty for the main plugin.
+	cmd         *exec.Cmd      // Process launched by Nagome
+	filtering   map[string]int // Origins of messages sent to the plugin as a filter by the token.  Used only in the dispatcher.
+	filterToks  []string       // Tokens in filtering in sent order
+}
+
+// pushFilterOrigin records the origin of the message sent to the plugin as a filter and
+// returns the token which the plugin sends back with the filtered message.
+// Old ones are dropped if the plugin doesn't send them back.
+func (pl *Plugin) pushFilterOrigin(origin int) string {
+	if pl.filtering == nil {
+		pl.filtering = make(map[string]int)
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	tok := hex.EncodeToString(b[:])
+	pl.filtering[tok] = origin
+	pl.filterToks = append(pl.filterToks, tok)
+	if len(pl.filterToks) > pluginFilterOriginsMax {
+		delete(pl.filtering, pl.filterToks[0])
+		pl.filterToks = pl.filterToks[1:]
+	}
+	return tok
+}
+
+// popFilterOrigin returns the origin of the message sent to the plugin with the token.
+// A token can be used only once.
+func (pl *Plugin) popFilterOrigin(tok string) (int, bool) {
+	o, ok := pl.filtering[tok]
+	if !ok {
+		return 0, false
+	}
+	delete(pl.filtering, tok)
+	for i, t := range pl.filterToks {
+		if t == tok {
+			pl.filterToks = append(pl.filterToks[:i], pl.filterToks[i+1:]...)
+			break
+		}
+	}
+	return o, true
+}
+
+// NewPlugin makes new Plugin of cv.
+// Add it to cv by AddPlugin before Open.
+func NewPlugin(cv *CommentViewer) *Plugin {
+	return newPlugin(cv)
 }
 
-// NewPlugin makes new Plugin.
 func newPlugin(cv *CommentViewer) *Plugin {
 	return &Plugin{
 		No:         -1,
@@ -85,6 +150,11 @@ func (pl *Plugin) Open(rwc io.ReadWriteCloser, enable bool) error {
 
 	pl.wg.Add(1)
 	go pl.evRoutine()
+	if pl.cv != nil && pl.cv.Settings.PluginPingInterval > 0 {
+		pl.wg.Add(1)
+		go pl.pingRoutine(time.Duration(pl.cv.Settings.PluginPingInterval)*time.Second,
+			pl.cv.Settings.PluginPingMissLimit, pl.cv.Settings.PluginPingAction)
+	}
 
 	var st pluginState
 	if enable {
@@ -157,13 +227,23 @@ func (pl *Plugin) IsSubscribe(pln string) bool {
 }
 
 // Load loads from file and set values.
+// It returns *PluginConfigError if the file has invalid values.
 func (pl *Plugin) Load(filePath string) error {
+	return pl.load(filePath, false)
+}
+
+// loadNormal is same as Load but also checks items which are necessary for normal plugins.
+func (pl *Plugin) loadNormal(filePath string) error {
+	return pl.load(filePath, true)
+}
+
+func (pl *Plugin) load(filePath string, normal bool) error {
 	d, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
 
-	return yaml.Unmarshal(d, pl)
+	return parsePluginConfig(filePath, d, pl, normal)
 }
 
 // Save saves the plugin into a file with given name.
@@ -176,6 +256,15 @@ func (pl *Plugin) Save(filePath string) error {
 	return ioutil.WriteFile(filePath, d, 0600)
 }
 
+// expandExec replaces the placeholders in Exec.
+func (pl *Plugin) expandExec(path, port string) {
+	for i := range pl.Exec {
+		pl.Exec[i] = strings.Replace(pl.Exec[i], "{{path}}", path, -1)
+		pl.Exec[i] = strings.Replace(pl.Exec[i], "{{port}}", port, -1)
+		pl.Exec[i] = strings.Replace(pl.Exec[i], "{{no}}", strconv.Itoa(pl.No), -1)
+	}
+}
+
 // IsMain returns whether the plugin is main plugin.
 func (pl *Plugin) IsMain() bool {
 	return pl.No == 0
@@ -190,9 +279,11 @@ func (pl *Plugin) evRoutine() {
 		}
 		pl.stateMu.Lock()
 		pl.GetState = pluginStateClose
+		atomic.StoreInt32(&pl.enabled, 0)
 		pl.stateMu.Unlock()
 	}()
 	defer pl.cv.cli.log.Printf("plugin [%s] is closing", pl.Name)
+	defer pl.cv.timers.CancelPlugin(pl)
 
 	// Run decoder.  It puts a message into "mes".
 	dec := json.NewDecoder(pl.rwc)
@@ -256,12 +347,20 @@ func (pl *Plugin) evRoutine() {
 				}
 				continue
 			}
+			if m.Domain == DomainDirect && m.Command == CommDirectPong {
+				pl.receivePong()
+				continue
+			}
 			if pl.GetState != pluginStateEnable {
 				continue
 			}
 			m.plgno = pl.No
+			m.origin = pl.No
 			pl.cv.cli.log.Printf("plugin message [%s] : %v", pl.Name, m)
-			pl.cv.Evch <- m
+			select {
+			case pl.cv.Evch <- m:
+			case <-pl.quit:
+			}
 
 		// Send a message
 		case m := <-pl.writec:
@@ -290,6 +389,11 @@ func (pl *Plugin) evRoutine() {
 					return
 				}
 				pl.GetState = e
+				if e == pluginStateEnable {
+					atomic.StoreInt32(&pl.enabled, 1)
+				} else {
+					atomic.StoreInt32(&pl.enabled, 0)
+				}
 
 				// send message
 				m := &Message{
@@ -307,6 +411,17 @@ func (pl *Plugin) evRoutine() {
 					return
 				}
 				writeMess(jm)
+
+				if e == pluginStateEnable && pl.Backlog && pl.IsSubscribe(DomainComment) {
+					for _, bm := range pl.cv.backlog.backlogMessages(0) {
+						jm, err := json.Marshal(bm)
+						if err != nil {
+							pl.cv.cli.log.Println(err)
+							continue
+						}
+						writeMess(jm)
+					}
+				}
 			}()
 
 		case <-pl.quit:
@@ -337,19 +452,35 @@ func handleTCPPlugin(c io.ReadWriteCloser, cv *CommentViewer) {
 					cv.cli.log.Println(err)
 				}
 			}
+		case <-time.After(pluginHandshakeDu):
+			cv.cli.log.Printf("a TCP plugin didn't send %s in %v\n", CommDirectNo, pluginHandshakeDu)
+			err := c.Close()
+			if err != nil {
+				cv.cli.log.Println(err)
+			}
 		}
 	}()
 
 	dec := json.NewDecoder(c)
 
 	m := new(Message)
-	// It may stop here long time
+	// It may stop here until the handshake deadline
 	err := dec.Decode(m)
 	if err != nil {
 		cv.cli.log.Println(err)
 		endc <- true
 		return
 	}
+	if m.Domain == DomainDirect && m.Command == CommDirectTraceInject {
+		if cv.tracer == nil {
+			cv.cli.log.Println("trace inject : tracing is not enabled")
+			endc <- true
+			return
+		}
+		endc <- false
+		cv.handleTraceInject(c, dec)
+		return
+	}
 	if m.Domain != DomainDirect || m.Command != CommDirectNo {
 		cv.cli.log.Println("send Direct.No message at first")
 		endc <- true
@@ -422,6 +553,7 @@ func handleSTDPlugin(p *Plugin, cv *CommentViewer, path string) {
 		cv.cli.log.Println(err)
 		return
 	}
+	p.cmd = cmd
 
 	c := &stdReadWriteCloser{stdout, stdin}
 	err = p.Open(c, !cv.Settings.PluginDisable[p.Name])
@@ -447,6 +579,43 @@ func (pl *Plugin) close() {
 	}
 }
 
+// disconnect closes the plugin and the connection without waiting for the plugin.
+// It also stops writing to the plugin which doesn't read messages.
+func (pl *Plugin) disconnect() {
+	pl.close()
+	if pl.rwc != nil {
+		err := pl.rwc.Close()
+		if err != nil {
+			pl.cv.cli.log.Println(err)
+		}
+	}
+	if pl.cmd != nil && pl.cmd.Process != nil {
+		err := pl.cmd.Process.Kill()
+		if err != nil {
+			pl.cv.cli.log.Println(err)
+		}
+	}
+}
+
+// stop disconnects the plugin and waits for its routines and process to end in timeout.
+func (pl *Plugin) stop(timeout time.Duration) error {
+	pl.disconnect()
+	done := make(chan struct{})
+	go func() {
+		pl.wg.Wait()
+		if pl.cmd != nil && pl.cmd.Process != nil {
+			_ = pl.cmd.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("plugin [%s] didn't stop in %v", pl.Name, timeout)
+	}
+}
+
 type stdReadWriteCloser struct {
 	io.ReadCloser
 	io.WriteCloser
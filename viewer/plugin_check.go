@@ -0,0 +1,225 @@
+package viewer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	pluginCheckNo          = 1
+	pluginCheckHandshakeDu = 10 * time.Second
+	pluginCheckListenDu    = 3 * time.Second
+)
+
+// pluginCheckSampleMessage returns a message which is sent to the plugin to check its subscription.
+func pluginCheckSampleMessage(dom string) *Message {
+	var m *Message
+	switch strings.TrimSuffix(dom, DomainSuffixFilter) {
+	case DomainNagome:
+		m = NewMessageMust(DomainNagome, CommNagomeBroadInfo, CtNagomeBroadInfo{"1", "1"})
+	case DomainComment:
+		m = NewMessageMust(DomainComment, CommCommentGot, CtCommentGot{
+			No:      1,
+			Date:    time.Now(),
+			Raw:     "plugin check",
+			Comment: "plugin check",
+			UserID:  "1",
+		})
+	case DomainUI:
+		m = NewMessageMust(DomainUI, CommUINotification, CtUINotification{CtUINotificationTypeInfo, "plugin check", "plugin check"})
+	case DomainAntenna:
+		m = NewMessageMust(DomainAntenna, CommAntennaGot, CtAntennaGot{"lv1", "co1", "1"})
+	case DomainQuery:
+		m = NewMessageMust(DomainQuery, CommQueryLogPrint, CtQueryLogPrint{"plugin check"})
+	default:
+		return nil
+	}
+	m.Domain = dom
+	return m
+}
+
+// checkPlugin validates the plugin.yml in dir, launches the plugin, verifies the handshake and
+// the subscription, and writes the report to out.
+func checkPlugin(dir string, out io.Writer) (err error) {
+	fail := func(format string, a ...interface{}) error {
+		err := fmt.Errorf(format, a...)
+		fmt.Fprintf(out, "NG   %s\n", err)
+		return err
+	}
+
+	pl := newPlugin(nil)
+	pl.No = pluginCheckNo
+	if err := pl.loadNormal(filepath.Join(dir, pluginConfigName)); err != nil {
+		return fail("%s", err)
+	}
+	fmt.Fprintf(out, "OK   %s : %s %s (%s)\n", pluginConfigName, pl.Name, pl.Version, pl.Method)
+
+	var l net.Listener
+	port := ""
+	if pl.Method == pluginMethodTCP {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fail("listen : %s", err)
+		}
+		defer func() {
+			_ = l.Close()
+		}()
+		_, port, err = net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			return fail("listen : %s", err)
+		}
+	}
+	pl.expandExec(dir, port)
+
+	cmd := exec.Command(pl.Exec[0], pl.Exec[1:]...)
+	cmd.Dir = dir
+	var rwc io.ReadWriteCloser
+	if pl.Method == pluginMethodStd {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return fail("exec : %s", err)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return fail("exec : %s", err)
+		}
+		rwc = &stdReadWriteCloser{stdout, stdin}
+	}
+	if err := cmd.Start(); err != nil {
+		return fail("exec %v : %s", pl.Exec, err)
+	}
+	fmt.Fprintf(out, "OK   exec : %v\n", pl.Exec)
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	// Run decoder
+	var dec *json.Decoder
+	mes := make(chan *Message)
+	decErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	startDecoder := func() {
+		go func() {
+			for {
+				m := new(Message)
+				if err := dec.Decode(m); err != nil {
+					decErr <- err
+					return
+				}
+				select {
+				case mes <- m:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	if pl.Method == pluginMethodTCP {
+		type acceptResult struct {
+			c   net.Conn
+			err error
+		}
+		ac := make(chan acceptResult, 1)
+		go func() {
+			c, err := l.Accept()
+			ac <- acceptResult{c, err}
+		}()
+		select {
+		case r := <-ac:
+			if r.err != nil {
+				return fail("handshake : %s", r.err)
+			}
+			rwc = r.c
+		case <-time.After(pluginCheckHandshakeDu):
+			return fail("handshake : the plugin didn't connect in %v", pluginCheckHandshakeDu)
+		}
+		defer func() {
+			_ = rwc.Close()
+		}()
+
+		dec = json.NewDecoder(rwc)
+		startDecoder()
+		select {
+		case m := <-mes:
+			if m.Domain != DomainDirect || m.Command != CommDirectNo {
+				return fail("handshake : the first message should be %s %s but %s %s", DomainDirect, CommDirectNo, m.Domain, m.Command)
+			}
+			var ct CtDirectNo
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return fail("handshake : %s", err)
+			}
+			if ct.No != pluginCheckNo {
+				return fail("handshake : sent No is %d but should be %d", ct.No, pluginCheckNo)
+			}
+		case err := <-decErr:
+			return fail("handshake : %s", err)
+		case <-time.After(pluginCheckHandshakeDu):
+			return fail("handshake : %s message was not sent in %v", CommDirectNo, pluginCheckHandshakeDu)
+		}
+		fmt.Fprintf(out, "OK   handshake\n")
+	} else {
+		dec = json.NewDecoder(rwc)
+		startDecoder()
+	}
+
+	enc := json.NewEncoder(rwc)
+	sent := []*Message{NewMessageMust(DomainDirectngm, CommDirectngmPlugEnabled, nil)}
+	for _, d := range pl.Subscribe {
+		if m := pluginCheckSampleMessage(d); m != nil {
+			sent = append(sent, m)
+		}
+	}
+	for _, m := range sent {
+		if err := enc.Encode(m); err != nil {
+			return fail("write %v : %s", m, err)
+		}
+		fmt.Fprintf(out, "OK   sent %s %s\n", m.Domain, m.Command)
+	}
+
+	filtered := make(map[string]bool)
+	invalid := 0
+	timeout := time.After(pluginCheckListenDu)
+readLoop:
+	for {
+		select {
+		case m := <-mes:
+			if verrs := ValidateContent(m.Domain, m.Command, m.Content); len(verrs) != 0 {
+				invalid++
+				fmt.Fprintf(out, "NG   received %s %s : %v\n", m.Domain, m.Command, verrs)
+				continue
+			}
+			fmt.Fprintf(out, "OK   received %s %s\n", m.Domain, m.Command)
+			if strings.HasSuffix(m.Domain, DomainSuffixFilter) {
+				filtered[m.Domain] = true
+			}
+		case err := <-decErr:
+			if err != io.EOF {
+				return fail("read : %s", err)
+			}
+			fmt.Fprintf(out, "--   the plugin closed the connection\n")
+			break readLoop
+		case <-timeout:
+			break readLoop
+		}
+	}
+
+	for _, d := range pl.Subscribe {
+		if strings.HasSuffix(d, DomainSuffixFilter) && !filtered[d] {
+			fmt.Fprintf(out, "--   no message was passed through the filter %s (dropped or delayed)\n", d)
+		}
+	}
+	if invalid != 0 {
+		return fail("%d invalid messages were received", invalid)
+	}
+	fmt.Fprintf(out, "OK   %s\n", pl.Name)
+	return nil
+}
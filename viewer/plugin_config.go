@@ -0,0 +1,183 @@
+package viewer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	yamlErrorLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+)
+
+// builtinDomains is the list of domains of Nagome which plugins can subscribe.
+// Plugins can also subscribe custom domains declared by plugins.
+var builtinDomains = []string{
+	DomainNagome,
+	DomainQuery,
+	DomainComment,
+	DomainUI,
+	DomainAntenna,
+}
+
+// A PluginConfigFieldError is an error at a line in a plugin.yml.
+// Line is 0 if the error is not related to any line.
+type PluginConfigFieldError struct {
+	Line int
+	Msg  string
+}
+
+// A PluginConfigError is returned when a plugin.yml is invalid.
+type PluginConfigError struct {
+	File string
+	Errs []PluginConfigFieldError
+}
+
+func (e *PluginConfigError) Error() string {
+	ss := make([]string, len(e.Errs))
+	for i, fe := range e.Errs {
+		if fe.Line == 0 {
+			ss[i] = fmt.Sprintf("%s: %s", e.File, fe.Msg)
+		} else {
+			ss[i] = fmt.Sprintf("%s:%d: %s", e.File, fe.Line, fe.Msg)
+		}
+	}
+	return strings.Join(ss, "\n")
+}
+
+// isValidPluginName returns whether the name can be used as a directory name in the plugin directory.
+func isValidPluginName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`+string(filepath.Separator))
+}
+
+// parsePluginConfig unmarshals plugin.yml strictly and validates the values.
+// If normal is true, it also checks items necessary for normal plugins.
+func parsePluginConfig(file string, d []byte, pl *Plugin, normal bool) error {
+	cerr := &PluginConfigError{File: file}
+
+	err := yaml.UnmarshalStrict(d, pl)
+	if err != nil {
+		terr, ok := err.(*yaml.TypeError)
+		if !ok {
+			// syntax error
+			cerr.Errs = append(cerr.Errs, yamlErrorToFieldError(strings.TrimPrefix(err.Error(), "yaml: ")))
+			return cerr
+		}
+		for _, e := range terr.Errors {
+			cerr.Errs = append(cerr.Errs, yamlErrorToFieldError(e))
+		}
+	}
+
+	if pl.Name == "" {
+		cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "name"), "name is empty"})
+	} else if !isValidPluginName(pl.Name) {
+		cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "name"),
+			fmt.Sprintf("invalid name \"%s\" (it is used as a directory name)", pl.Name)})
+	}
+	if pl.Method != pluginMethodStd && pl.Method != pluginMethodTCP {
+		cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "method"),
+			fmt.Sprintf("invalid method \"%s\" (select from %s, %s)", pl.Method, pluginMethodStd, pluginMethodTCP)})
+	}
+	if normal && len(pl.Exec) == 0 {
+		cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "exec"), "exec is not specified"})
+	}
+	for _, s := range pl.Subscribe {
+		if !isValidSubscribeDomain(s) {
+			cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlItemLine(d, "subscribe", s),
+				fmt.Sprintf("unknown domain \"%s\" in subscribe", s)})
+		}
+	}
+
+	for _, dm := range pl.Domains {
+		if !isValidCustomDomain(dm) {
+			cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlItemLine(d, "domains", dm),
+				fmt.Sprintf("invalid domain name \"%s\" in domains (use [A-Za-z0-9_.-] and don't start with \"%s\")", dm, reservedDomainPrefix)})
+		}
+	}
+	for _, p := range pl.Permissions {
+		if !isValidPermission(p) {
+			cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlItemLine(d, "permissions", p),
+				fmt.Sprintf("unknown permission \"%s\" in permissions", p)})
+		}
+	}
+
+	keys := make(map[string]bool)
+	for i := range pl.Settings {
+		ps := &pl.Settings[i]
+		if err := ps.validate(); err != nil {
+			cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "settings"), err.Error()})
+		}
+		if keys[ps.Key] {
+			cerr.Errs = append(cerr.Errs, PluginConfigFieldError{yamlKeyLine(d, "settings"),
+				fmt.Sprintf("setting \"%s\" is declared twice", ps.Key)})
+		}
+		keys[ps.Key] = true
+	}
+
+	if len(cerr.Errs) != 0 {
+		return cerr
+	}
+	return nil
+}
+
+func isValidSubscribeDomain(s string) bool {
+	s = strings.TrimSuffix(s, DomainSuffixFilter)
+	for _, d := range builtinDomains {
+		if s == d {
+			return true
+		}
+	}
+	return isValidCustomDomain(s)
+}
+
+func yamlErrorToFieldError(e string) PluginConfigFieldError {
+	m := yamlErrorLineRegex.FindStringSubmatch(e)
+	if m == nil {
+		return PluginConfigFieldError{0, e}
+	}
+	l, _ := strconv.Atoi(m[1])
+	return PluginConfigFieldError{l, m[2]}
+}
+
+// yamlKeyLine returns the line number of a top-level key, or 0 if it is not found.
+func yamlKeyLine(d []byte, key string) int {
+	sc := bufio.NewScanner(bytes.NewReader(d))
+	for l := 1; sc.Scan(); l++ {
+		if strings.HasPrefix(sc.Text(), key+":") {
+			return l
+		}
+	}
+	return 0
+}
+
+// yamlItemLine returns the line number of an item of a top-level list.
+// It returns the line of the key if the item is not found (e.g. flow style).
+func yamlItemLine(d []byte, key, item string) int {
+	kl := yamlKeyLine(d, key)
+	if kl == 0 {
+		return 0
+	}
+	sc := bufio.NewScanner(bytes.NewReader(d))
+	for l := 1; sc.Scan(); l++ {
+		if l <= kl {
+			continue
+		}
+		t := sc.Text()
+		if t != "" && t[0] != ' ' && t[0] != '-' && t[0] != '#' {
+			// next key
+			break
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(t), "-"))
+		if strings.Trim(v, `"'`) == item {
+			return l
+		}
+	}
+	return kl
+}
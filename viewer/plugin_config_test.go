@@ -0,0 +1,90 @@
+package viewer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParsePluginConfig(t *testing.T) {
+	tests := []struct {
+		yml    string
+		normal bool
+		lines  []int
+	}{
+		{"name: a\nmethod: std\nexec: [a]\nsubscribe:\n- nagome\n- nagome_comment@filter\n", true, nil},
+		{"name: a\nmethod: std\n", false, nil},
+		{"name: a\nmethod: std\n", true, []int{0}},
+		{"name: a\nmethod: tpc\nexec: [a]\n", true, []int{2}},
+		{"name: a\nmethod: std\nexec: [a]\nsubscrib:\n- nagome\n", true, []int{4}},
+		{"name: a\nmethod: std\nexec: [a]\nsubscribe:\n- nagome\n- 'nagome_coment'\n", true, []int{6}},
+		{"name: a\nmethod: std\nexec: [a]\nsubscribe: [nagome_coment]\n", true, []int{4}},
+		{"name: [a\n", true, []int{1}},
+		{"description: a\nmethod: std\nexec: [a]\n", true, []int{0}},
+		{"name: ../../x\nmethod: std\n", false, []int{1}},
+		{"name: a\\b\nmethod: std\n", false, []int{1}},
+		{"name: '..'\nmethod: std\n", false, []int{1}},
+		{"name: a\nmethod: std\ndomains: [tts]\nsubscribe: [overlay, overlay@filter]\n", false, nil},
+		{"name: a\nmethod: std\ndomains:\n- tts\n- nagome_tts\n- 't s'\n", false, []int{5, 6}},
+		{"name: a\nmethod: std\nsettings:\n- key: b\n  type: int\n  default: 1\n", false, nil},
+		{"name: a\nmethod: std\nsettings:\n- key: b\n  type: int\n  default: x\n", false, []int{3}},
+		{"name: a\nmethod: std\nsettings:\n- key: b\n  type: bool\n- key: b\n  type: bool\n", false, []int{3}},
+		{"name: a\nmethod: std\npermissions:\n- comment.send\n- acount\n", false, []int{5}},
+	}
+
+	for _, test := range tests {
+		pl := newPlugin(nil)
+		err := parsePluginConfig("plugin.yml", []byte(test.yml), pl, test.normal)
+		if test.lines == nil {
+			if err != nil {
+				t.Fatalf("%q : %v", test.yml, err)
+			}
+			continue
+		}
+		cerr, ok := err.(*PluginConfigError)
+		if !ok {
+			t.Fatalf("%q : Should be PluginConfigError but %v", test.yml, err)
+		}
+		var lines []int
+		for _, e := range cerr.Errs {
+			lines = append(lines, e.Line)
+		}
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Fatalf("%q : Should be %v but %v", test.yml, test.lines, cerr)
+		}
+	}
+}
+
+func TestCheckPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cat is not available")
+	}
+
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// cat passes all messages through
+	yml := "name: cat\nmethod: std\nexec: [cat]\nsubscribe: [nagome_comment@filter]\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, pluginConfigName), []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	if err := checkPlugin(dir, b); err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+	if !bytes.Contains(b.Bytes(), []byte("received nagome_comment@filter Got")) {
+		t.Fatalf("The filter should be reported :\n%s", b)
+	}
+}
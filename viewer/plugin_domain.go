@@ -0,0 +1,85 @@
+package viewer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reservedDomainPrefix is the prefix of domains of Nagome.  Plugins can't declare a domain with it.
+const reservedDomainPrefix = "nagome"
+
+var customDomainRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func isBuiltinDomain(d string) bool {
+	for _, b := range builtinDomains {
+		if d == b {
+			return true
+		}
+	}
+	return d == DomainDirect || d == DomainDirectngm
+}
+
+// isValidCustomDomain returns true if the name can be used as a domain declared by a plugin.
+func isValidCustomDomain(d string) bool {
+	return customDomainRegex.MatchString(d) && !strings.HasPrefix(d, reservedDomainPrefix)
+}
+
+// IsDeclareDomain returns whether the plugin declares the custom domain in plugin.yml.
+func (pl *Plugin) IsDeclareDomain(d string) bool {
+	for _, pd := range pl.Domains {
+		if pd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCustomDomain returns an error if the plugin which sent the message is not allowed to send into the domain.
+// A message in a custom domain can be sent by plugins which declare the domain.
+// A message with the filter suffix can be sent back by plugins which filter the domain.
+func (cv *CommentViewer) checkCustomDomain(m *Message) error {
+	d := strings.TrimSuffix(m.Domain, DomainSuffixFilter)
+	if m.plgno < 0 || isBuiltinDomain(d) {
+		return nil
+	}
+	pl, err := cv.Plugin(m.plgno)
+	if err != nil {
+		return err
+	}
+	if pl.IsMain() {
+		return nil
+	}
+	if strings.HasSuffix(m.Domain, DomainSuffixFilter) {
+		if pl.IsSubscribe(m.Domain) {
+			return nil
+		}
+		return fmt.Errorf("plugin [%s] doesn't filter the domain \"%s\"", pl.Name, d)
+	}
+	if pl.IsDeclareDomain(d) {
+		return nil
+	}
+	return fmt.Errorf("plugin [%s] doesn't declare the domain \"%s\" in %s", pl.Name, d, pluginConfigName)
+}
+
+// warnUndeclaredDomains logs custom domains which are subscribed but declared by no plugins.
+func (cv *CommentViewer) warnUndeclaredDomains() {
+	for _, p := range cv.Pgns {
+		for _, s := range p.Subscribe {
+			d := strings.TrimSuffix(s, DomainSuffixFilter)
+			if isBuiltinDomain(d) {
+				continue
+			}
+			declared := false
+			for _, dp := range cv.Pgns {
+				if dp.IsDeclareDomain(d) {
+					declared = true
+					break
+				}
+			}
+			if !declared {
+				cv.cli.log.Printf("plugin [%s] subscribes \"%s\" but no plugin declares it\n", p.Name, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package viewer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckCustomDomain(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for _, p := range []*Plugin{
+		{Name: "main"},
+		{Name: "tts", Domains: []string{"tts"}},
+		{Name: "filter", Subscribe: []string{"tts@filter"}},
+		{Name: "other", Subscribe: []string{"tts"}},
+	} {
+		cv.AddPlugin(p)
+	}
+
+	tests := []struct {
+		plgno  int
+		domain string
+		ok     bool
+	}{
+		{1, "tts", true},
+		{1, DomainQuery, true},
+		{2, "tts@filter", true},
+		{2, "tts", false},
+		{3, "tts", false},
+		{3, "tts@filter", false},
+		{3, "overlay", false},
+		{0, "overlay", true},
+	}
+	for _, test := range tests {
+		m := NewMessageMust(test.domain, "Speak", nil)
+		m.plgno = test.plgno
+		err := cv.checkCustomDomain(m)
+		if (err == nil) != test.ok {
+			t.Errorf("%s from %s : %v", test.domain, cv.PluginName(test.plgno), err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package viewer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	pluginInstallTmpPrefix = ".install-"
+)
+
+// An InstalledPlugin is a plugin installed in the plugin directory.
+type InstalledPlugin struct {
+	Dir         string `json:"dir"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+}
+
+// A PluginConflictError is returned when plugins declare the same name.
+type PluginConflictError struct {
+	Name string
+	Dirs []string
+}
+
+func (e *PluginConflictError) Error() string {
+	return fmt.Sprintf("plugin name \"%s\" is declared in multiple directories : %s", e.Name, strings.Join(e.Dirs, ", "))
+}
+
+// listInstalledPlugins returns installed plugins in the plugin directory sorted by the directory name.
+// Plugins that have the same name are reported as PluginConflictError in errs.
+func listInstalledPlugins(pluginPath string) (ps []InstalledPlugin, errs []error) {
+	ds, err := ioutil.ReadDir(pluginPath)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	dirs := make(map[string][]string)
+	for _, d := range ds {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		p := newPlugin(nil)
+		err := p.loadNormal(filepath.Join(pluginPath, d.Name(), pluginConfigName))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed load plugin [%s] : %s", d.Name(), err))
+			continue
+		}
+		ps = append(ps, InstalledPlugin{
+			Dir:         d.Name(),
+			Name:        p.Name,
+			Version:     p.Version,
+			Description: p.Description,
+			Author:      p.Author,
+		})
+		dirs[p.Name] = append(dirs[p.Name], d.Name())
+	}
+
+	names := make([]string, 0, len(dirs))
+	for n := range dirs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if len(dirs[n]) > 1 {
+			errs = append(errs, &PluginConflictError{n, dirs[n]})
+		}
+	}
+	return ps, errs
+}
+
+// findInstalledPlugin returns the installed plugin which has the given name.
+func findInstalledPlugin(pluginPath, name string) (*InstalledPlugin, error) {
+	ps, _ := listInstalledPlugins(pluginPath)
+	var found *InstalledPlugin
+	for i := range ps {
+		if ps[i].Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, &PluginConflictError{name, []string{found.Dir, ps[i].Dir}}
+		}
+		found = &ps[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("plugin \"%s\" is not installed", name)
+	}
+	return found, nil
+}
+
+// installPlugin installs a plugin from a zip, tar.gz file or a directory.
+// If upgrade is true, it overwrites the installed plugin which has the same name.
+// Files which are not in the package (data of the plugin) are preserved in upgrading.
+// It returns the installed plugin and the previous version if it is upgraded.
+func installPlugin(src, pluginPath string, upgrade bool) (ip *InstalledPlugin, oldVersion string, err error) {
+	if err := os.MkdirAll(pluginPath, 0777); err != nil {
+		return nil, "", err
+	}
+	tmp, err := ioutil.TempDir(pluginPath, pluginInstallTmpPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if rerr := os.RemoveAll(tmp); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case fi.IsDir():
+		err = copyDir(src, tmp)
+	case strings.HasSuffix(src, ".zip"):
+		err = extractZip(src, tmp)
+	case strings.HasSuffix(src, ".tar.gz"), strings.HasSuffix(src, ".tgz"):
+		err = extractTarGz(src, tmp)
+	default:
+		err = fmt.Errorf("unsupported package format : %s", src)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	root, err := findPackageRoot(tmp)
+	if err != nil {
+		return nil, "", err
+	}
+	p := newPlugin(nil)
+	if err := p.loadNormal(filepath.Join(root, pluginConfigName)); err != nil {
+		return nil, "", err
+	}
+
+	if !isValidPluginName(p.Name) {
+		return nil, "", fmt.Errorf("invalid plugin name : %s", p.Name)
+	}
+	dst := filepath.Join(pluginPath, p.Name)
+	old, ferr := findInstalledPlugin(pluginPath, p.Name)
+	if ferr == nil {
+		if !upgrade {
+			return nil, "", &PluginConflictError{p.Name, []string{old.Dir, src}}
+		}
+		dst = filepath.Join(pluginPath, old.Dir)
+		oldVersion = old.Version
+	} else if _, ok := ferr.(*PluginConflictError); ok {
+		return nil, "", ferr
+	} else if _, err := os.Stat(dst); err == nil {
+		return nil, "", fmt.Errorf("directory \"%s\" is used by another plugin", dst)
+	}
+
+	if filepath.Dir(filepath.Clean(dst)) != filepath.Clean(pluginPath) {
+		return nil, "", fmt.Errorf("plugin directory \"%s\" is out of %s", dst, pluginPath)
+	}
+	if err := copyDir(root, dst); err != nil {
+		return nil, "", err
+	}
+
+	return &InstalledPlugin{
+		Dir:         filepath.Base(dst),
+		Name:        p.Name,
+		Version:     p.Version,
+		Description: p.Description,
+		Author:      p.Author,
+	}, oldVersion, nil
+}
+
+// uninstallPlugin removes the directory of the installed plugin which has the given name.
+func uninstallPlugin(name, pluginPath string) (*InstalledPlugin, error) {
+	ip, err := findInstalledPlugin(pluginPath, name)
+	if err != nil {
+		return nil, err
+	}
+	return ip, os.RemoveAll(filepath.Join(pluginPath, ip.Dir))
+}
+
+// findPackageRoot returns the directory which has plugin.yml.
+// Archives often have one top-level directory, so it is also searched.
+func findPackageRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, pluginConfigName)); err == nil {
+		return dir, nil
+	}
+	ds, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(ds) == 1 && ds[0].IsDir() {
+		sub := filepath.Join(dir, ds[0].Name())
+		if _, err := os.Stat(filepath.Join(sub, pluginConfigName)); err == nil {
+			return sub, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not found in the package", pluginConfigName)
+}
+
+// safeJoin joins the name in an archive to dir and rejects paths going out of dir.
+func safeJoin(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if p != dir && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file path in the package : %s", name)
+	}
+	return p, nil
+}
+
+func writeFile(p string, r io.Reader, mode os.FileMode) (err error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func extractZip(src, dir string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+
+	for _, f := range zr.File {
+		p, err := safeJoin(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(p, 0777); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(p, rc, f.Mode())
+		if cerr := rc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := safeJoin(dir, h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(p, tr, os.FileMode(h.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyDir copies files in src into dst recursively.  Existing files in dst are overwritten.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		t := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(t, 0777)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		return writeFile(t, f, fi.Mode())
+	})
+}
@@ -0,0 +1,179 @@
+package viewer
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestPluginPackage(t *testing.T, dir, name, version string) {
+	pl := Plugin{
+		Name:    name,
+		Version: version,
+		Method:  pluginMethodStd,
+		Exec:    []string{"true"},
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := pl.Save(filepath.Join(dir, pluginConfigName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.sh"), []byte(version), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeTestZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for n, c := range files {
+		w, err := zw.Create(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	pluginPath := filepath.Join(dir, pluginDirName)
+
+	src := filepath.Join(dir, "src1")
+	makeTestPluginPackage(t, src, "test", "1.0")
+	ip, _, err := installPlugin(src, pluginPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.Name != "test" || ip.Version != "1.0" {
+		t.Fatalf("Unexpected installed plugin %v", ip)
+	}
+
+	// data of the plugin
+	dataPath := filepath.Join(pluginPath, ip.Dir, "data.txt")
+	if err := ioutil.WriteFile(dataPath, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// same name
+	src2 := filepath.Join(dir, "src2")
+	makeTestPluginPackage(t, src2, "test", "2.0")
+	_, _, err = installPlugin(src2, pluginPath, false)
+	if _, ok := err.(*PluginConflictError); !ok {
+		t.Fatalf("Should be PluginConflictError but %v", err)
+	}
+
+	// upgrade
+	ip, oldv, err := installPlugin(src2, pluginPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldv != "1.0" || ip.Version != "2.0" {
+		t.Fatalf("Should be upgraded from 1.0 to 2.0 but %v to %v", oldv, ip.Version)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(pluginPath, ip.Dir, "main.sh")); err != nil || string(b) != "2.0" {
+		t.Fatalf("File should be upgraded : %s %v", b, err)
+	}
+	if _, err := os.Stat(dataPath); err != nil {
+		t.Fatalf("Data should be preserved : %v", err)
+	}
+
+	// zip with a top-level directory
+	zipPath := filepath.Join(dir, "zipped.zip")
+	makeTestZip(t, zipPath, map[string]string{
+		"zipped/plugin.yml": "name: zipped\nversion: \"0.1\"\nmethod: tcp\nexec: [python3, main.py]\n",
+		"zipped/main.py":    "",
+	})
+	ip, _, err = installPlugin(zipPath, pluginPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pluginPath, ip.Dir, "main.py")); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, errs := listInstalledPlugins(pluginPath)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("Should be %v but %v", 2, len(ps))
+	}
+
+	// conflict in the directory
+	makeTestPluginPackage(t, filepath.Join(pluginPath, "copied"), "test", "3.0")
+	if _, errs := listInstalledPlugins(pluginPath); len(errs) != 1 {
+		t.Fatalf("Should report a conflict but %v", errs)
+	}
+	if _, err := uninstallPlugin("test", pluginPath); err == nil {
+		t.Fatal("Should be failed because of the conflict")
+	}
+	if err := os.RemoveAll(filepath.Join(pluginPath, "copied")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uninstallPlugin("test", pluginPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findInstalledPlugin(pluginPath, "test"); err == nil {
+		t.Fatal("Should be uninstalled")
+	}
+}
+
+func TestPluginInstallInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	pluginPath := filepath.Join(dir, pluginDirName)
+
+	tests := map[string]map[string]string{
+		"noyml.zip":     {"main.py": ""},
+		"nomethod.zip":  {"plugin.yml": "name: a\nexec: [a]\n"},
+		"noname.zip":    {"plugin.yml": "method: std\nexec: [a]\n"},
+		"badname.zip":   {"plugin.yml": "name: ../../x\nmethod: std\nexec: [a]\n"},
+		"traversal.zip": {"plugin.yml": "name: a\nmethod: std\nexec: [a]\n", "../../evil": ""},
+	}
+	for n, fs := range tests {
+		p := filepath.Join(dir, n)
+		makeTestZip(t, p, fs)
+		if _, _, err := installPlugin(p, pluginPath, false); err == nil {
+			t.Fatalf("%s : Should be failed", n)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		t.Fatal("File should not be extracted out of the directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); err == nil {
+		t.Fatal("Plugin should not be installed out of the directory")
+	}
+}
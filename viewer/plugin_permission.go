@@ -0,0 +1,189 @@
+package viewer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Permissions which plugins declare in plugin.yml
+const (
+	PluginPermCommentSend   = "comment.send"   // Broad.SendComment
+	PluginPermAccount       = "account"        // Account.*
+	PluginPermSettingsRead  = "settings.read"  // Settings.Current and Settings.All in Direct
+	PluginPermSettingsWrite = "settings.write" // Settings.SetCurrent and Settings.SetAll
+	PluginPermUserDBWrite   = "userdb.write"   // User.Set, User.SetName, User.Delete and User.Fetch
+	PluginPermPlugin        = "plugin"         // Plug.Enable, Plug.Restart, Plug.Install, Plug.Uninstall, Plug.Settings.Set and Plug.Settings.Get of other plugins
+	PluginPermQueryFilter   = "query.filter"   // Subscribing nagome_query@filter
+)
+
+var pluginPermissions = []string{
+	PluginPermCommentSend,
+	PluginPermAccount,
+	PluginPermSettingsRead,
+	PluginPermSettingsWrite,
+	PluginPermUserDBWrite,
+	PluginPermPlugin,
+	PluginPermQueryFilter,
+}
+
+// commandPermissions is the permission which is needed to send the command.
+// Commands not in this map can be sent by any plugin.
+var commandPermissions = map[string]map[string]string{
+	DomainQuery: {
+		CommQueryBroadSendComment:   PluginPermCommentSend,
+		CommQueryAccountSet:         PluginPermAccount,
+		CommQueryAccountLogin:       PluginPermAccount,
+		CommQueryAccountLoad:        PluginPermAccount,
+		CommQueryAccountSave:        PluginPermAccount,
+		CommQuerySettingsSetCurrent: PluginPermSettingsWrite,
+		CommQuerySettingsSetAll:     PluginPermSettingsWrite,
+		CommQueryPlugEnable:         PluginPermPlugin,
+		CommQueryPlugRestart:        PluginPermPlugin,
+		CommQueryPlugInstall:        PluginPermPlugin,
+		CommQueryPlugUninstall:      PluginPermPlugin,
+		CommQueryPlugSettingsSet:    PluginPermPlugin,
+		CommQueryUserSet:            PluginPermUserDBWrite,
+		CommQueryUserSetName:        PluginPermUserDBWrite,
+		CommQueryUserDelete:         PluginPermUserDBWrite,
+		CommQueryUserFetch:          PluginPermUserDBWrite,
+		CommQueryUserSetNote:        PluginPermUserDBWrite,
+		CommQueryUserSetTags:        PluginPermUserDBWrite,
+		CommQueryUserSetColor:       PluginPermUserDBWrite,
+		CommQueryUserSetHide:        PluginPermUserDBWrite,
+		CommQueryUserBackup:         PluginPermUserDBWrite,
+		CommQueryNGAdd:              PluginPermSettingsWrite,
+		CommQueryNGRemove:           PluginPermSettingsWrite,
+	},
+	DomainDirect: {
+		CommDirectSettingsCurrent: PluginPermSettingsRead,
+		CommDirectSettingsAll:     PluginPermSettingsRead,
+		CommDirectNGList:          PluginPermSettingsRead,
+	},
+}
+
+func isValidPermission(perm string) bool {
+	for _, p := range pluginPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// A PluginPermissionError is returned when a plugin sends a command without the permission.
+type PluginPermissionError struct {
+	Plugin     string
+	Command    string
+	Permission string
+}
+
+func (e *PluginPermissionError) Error() string {
+	return fmt.Sprintf("plugin [%s] is not permitted to send %s (needs \"%s\")", e.Plugin, e.Command, e.Permission)
+}
+
+// HasPermission returns true if the permission is declared in plugin.yml and granted by the user.
+// The main plugin has all permissions.
+func (pl *Plugin) HasPermission(perm string) bool {
+	if pl.IsMain() {
+		return true
+	}
+	return pl.isDeclared(perm) && pl.isGranted(perm)
+}
+
+func (pl *Plugin) isDeclared(perm string) bool {
+	for _, p := range pl.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func (pl *Plugin) isGranted(perm string) bool {
+	for _, p := range pl.Granted {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ungrantedPermissions returns declared permissions which are not granted yet.
+func (pl *Plugin) ungrantedPermissions() []string {
+	var ps []string
+	for _, p := range pl.Permissions {
+		if !pl.isGranted(p) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// canFilter returns whether the plugin is permitted to filter messages in the domain.
+// Filters of queries can change queries of other plugins.
+func (pl *Plugin) canFilter(dom string) bool {
+	return dom != DomainQuery || pl.HasPermission(PluginPermQueryFilter)
+}
+
+// checkPermission returns a PluginPermissionError if the origin of the message doesn't have
+// the permission to send it.
+func (cv *CommentViewer) checkPermission(m *Message) error {
+	perm, ok := commandPermissions[strings.TrimSuffix(m.Domain, DomainSuffixFilter)][m.Command]
+	if !ok || m.origin < 0 {
+		return nil
+	}
+	pl, err := cv.Plugin(m.origin)
+	if err != nil {
+		return err
+	}
+	if !pl.HasPermission(perm) {
+		return &PluginPermissionError{pl.Name, m.Domain + " " + m.Command, perm}
+	}
+	return nil
+}
+
+// loadGrantedPermissions sets the permissions of the plugin which the user granted before.
+func (cv *CommentViewer) loadGrantedPermissions(pl *Plugin) error {
+	pl.Granted = nil
+	for _, p := range pl.Permissions {
+		_, found, err := cv.plugStrg.Get(pluginStorageNsPermissions, pl.Name, p)
+		if err != nil {
+			return err
+		}
+		if found {
+			pl.Granted = append(pl.Granted, p)
+		}
+	}
+	return nil
+}
+
+// grantPermissions grants or revokes the permissions of the plugin and saves them.
+func (cv *CommentViewer) grantPermissions(pl *Plugin, perms []string, allow bool) error {
+	for _, p := range perms {
+		if !pl.isDeclared(p) {
+			return fmt.Errorf("plugin [%s] doesn't declare the permission \"%s\"", pl.Name, p)
+		}
+	}
+	for _, p := range perms {
+		var err error
+		if allow {
+			err = cv.plugStrg.Set(pluginStorageNsPermissions, pl.Name, p, json.RawMessage("true"))
+		} else {
+			err = cv.plugStrg.Delete(pluginStorageNsPermissions, pl.Name, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return cv.loadGrantedPermissions(pl)
+}
+
+// requestPermissions asks the user to grant the permissions of the plugin which are not granted yet.
+func (cv *CommentViewer) requestPermissions(pl *Plugin) {
+	ps := pl.ungrantedPermissions()
+	if len(ps) == 0 {
+		return
+	}
+	cv.Evch <- NewMessageMust(DomainUI, CommUIPlugPermission, CtUIPlugPermission{pl.No, pl.Name, ps})
+}
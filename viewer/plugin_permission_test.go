@@ -0,0 +1,81 @@
+package viewer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPluginPermission(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	mp := newPlugin(cv)
+	mp.Name = "main"
+	cv.AddPlugin(mp)
+	p := newPlugin(cv)
+	p.Name = "normal"
+	p.Permissions = []string{PluginPermCommentSend, PluginPermUserDBWrite}
+	cv.AddPlugin(p)
+
+	send := func(plgno int) error {
+		m := NewMessageMust(DomainQuery, CommQueryBroadSendComment, CtQueryBroadSendComment{Text: "a"})
+		m.plgno = plgno
+		m.origin = plgno
+		return cv.checkPermission(m)
+	}
+	if err := send(-1); err != nil {
+		t.Fatal("Nagome internal messages should be permitted : ", err)
+	}
+	if err := send(mp.No); err != nil {
+		t.Fatal("The main plugin should be permitted : ", err)
+	}
+	if _, ok := send(p.No).(*PluginPermissionError); !ok {
+		t.Fatal("Not granted permission should be rejected")
+	}
+	if ps := p.ungrantedPermissions(); len(ps) != 2 {
+		t.Fatalf("Should be 2 ungranted permissions but %v", ps)
+	}
+
+	if err := cv.grantPermissions(p, []string{PluginPermAccount}, true); err == nil {
+		t.Fatal("Undeclared permission should not be granted")
+	}
+	if err := cv.grantPermissions(p, []string{PluginPermCommentSend}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(p.No); err != nil {
+		t.Fatal("Granted permission should be permitted : ", err)
+	}
+
+	// Granted permissions are saved
+	p.Granted = nil
+	if err := cv.loadGrantedPermissions(p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasPermission(PluginPermCommentSend) || p.HasPermission(PluginPermUserDBWrite) {
+		t.Fatalf("Granted permissions are not loaded : %v", p.Granted)
+	}
+
+	if err := cv.grantPermissions(p, []string{PluginPermCommentSend}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(p.No); err == nil {
+		t.Fatal("Revoked permission should be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+package viewer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Actions when a plugin doesn't answer pings
+const (
+	PluginPingActionNotify  = "notify"  // Notify the user only
+	PluginPingActionDisable = "disable" // Disconnect the plugin
+	PluginPingActionRestart = "restart" // Disconnect and launch the plugin again
+)
+
+// States of pings of a plugin shown in Plug.List
+const (
+	pluginPingStateOK           = "ok"
+	pluginPingStateUnresponsive = "unresponsive"
+)
+
+// A pluginPing is the liveness state of a plugin.
+// Plugins are monitored after they answer a ping at least once, so plugins which don't support pings are not affected.
+// Plugins declaring "ping" in plugin.yml are monitored from the start, so they can't hang before the first answer.
+type pluginPing struct {
+	seq          int
+	missed       int
+	answered     bool
+	unresponsive bool
+}
+
+// receivePong is called when the plugin answers a ping.
+func (pl *Plugin) receivePong() {
+	pl.pingMu.Lock()
+	defer pl.pingMu.Unlock()
+	if pl.ping.unresponsive {
+		pl.cv.cli.log.Printf("plugin [%s] is responding again\n", pl.Name)
+	}
+	pl.ping = pluginPing{seq: pl.ping.seq, answered: true}
+}
+
+// pingState returns the state shown in Plug.List.  It is empty if the plugin is not monitored.
+func (pl *Plugin) pingState() string {
+	pl.pingMu.Lock()
+	defer pl.pingMu.Unlock()
+	switch {
+	case pl.ping.unresponsive:
+		return pluginPingStateUnresponsive
+	case pl.ping.answered || pl.Ping:
+		return pluginPingStateOK
+	}
+	return ""
+}
+
+// pingRoutine sends pings to the plugin periodically and takes the action when it misses limit pings in a row.
+func (pl *Plugin) pingRoutine(du time.Duration, limit int, action string) {
+	defer pl.wg.Done()
+
+	t := time.NewTicker(du)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-pl.quit:
+			return
+		}
+		if atomic.LoadInt32(&pl.enabled) == 0 {
+			continue
+		}
+
+		pl.pingMu.Lock()
+		fire := (pl.ping.answered || pl.Ping) && !pl.ping.unresponsive && pl.ping.missed >= limit
+		if fire {
+			pl.ping.unresponsive = true
+		}
+		pl.ping.seq++
+		pl.ping.missed++
+		seq := pl.ping.seq
+		pl.pingMu.Unlock()
+
+		if fire {
+			pl.cv.pluginUnresponsive(pl, action)
+			if action == PluginPingActionDisable || action == PluginPingActionRestart {
+				return
+			}
+		}
+
+		jm, err := json.Marshal(NewMessageMust(DomainDirectngm, CommDirectngmPing, CtDirectngmPing{seq}))
+		if err != nil {
+			pl.cv.cli.log.Println(err)
+			continue
+		}
+		// Don't wait for the plugin which doesn't read messages.
+		select {
+		case pl.writec <- jm:
+		default:
+		}
+	}
+}
+
+// pluginUnresponsive takes the action for the plugin which doesn't answer pings.
+func (cv *CommentViewer) pluginUnresponsive(pl *Plugin, action string) {
+	cv.cli.log.Printf("plugin [%s] doesn't answer pings (action : %s)\n", pl.Name, action)
+	if pl.IsMain() {
+		action = PluginPingActionNotify
+	}
+
+	switch action {
+	case PluginPingActionDisable:
+		pl.disconnect()
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Plugin not responding",
+			fmt.Sprintf("plugin [%s] is not responding and disconnected", pl.Name))
+	case PluginPingActionRestart:
+		pl.disconnect()
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Plugin not responding",
+			fmt.Sprintf("plugin [%s] is not responding and restarting", pl.Name))
+		cv.Evch <- NewMessageMust(DomainQuery, CommQueryPlugRestart, CtQueryPlugRestart{pl.No})
+	default:
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Plugin not responding",
+			fmt.Sprintf("plugin [%s] is not responding", pl.Name))
+	}
+}
@@ -0,0 +1,144 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPluginPing(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	cv.Settings.PluginPingInterval = 0
+	p := newPlugin(cv)
+	p.Name = "normal"
+	p.Method = pluginMethodTCP
+	cv.AddPlugin(newPlugin(cv))
+	cv.AddPlugin(p)
+
+	pr, pluginw := io.Pipe()
+	pwreader, pw := io.Pipe()
+	rwc := &testRwc{pr, pw, pr, t}
+	if err := p.Open(rwc, true); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if s := p.pingState(); s != "" {
+		t.Fatalf("Should not be monitored before answering but %s", s)
+	}
+
+	p.wg.Add(1)
+	go p.pingRoutine(10*time.Millisecond, 2, PluginPingActionNotify)
+
+	// Answer the first ping only
+	dec := json.NewDecoder(pwreader)
+	go func() {
+		answered := false
+		for {
+			m := new(Message)
+			if err := dec.Decode(m); err != nil {
+				return
+			}
+			if m.Command != CommDirectngmPing || answered {
+				continue
+			}
+			answered = true
+			var ct CtDirectngmPing
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				t.Error(err)
+				return
+			}
+			err := json.NewEncoder(pluginw).Encode(NewMessageMust(DomainDirect, CommDirectPong, CtDirectPong(ct)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case m := <-cv.Evch:
+			if m.Domain != DomainUI || m.Command != CommUINotification {
+				continue
+			}
+			if s := p.pingState(); s != pluginPingStateUnresponsive {
+				t.Fatalf("Should be %s but %s", pluginPingStateUnresponsive, s)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("The plugin missing pings was not detected (%s)", p.pingState())
+		}
+	}
+}
+
+func TestPluginPingDeclared(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	cv.Settings.PluginPingInterval = 0
+	p := newPlugin(cv)
+	p.Name = "declared"
+	p.Method = pluginMethodTCP
+	p.Ping = true
+	cv.AddPlugin(newPlugin(cv))
+	cv.AddPlugin(p)
+
+	pr, _ := io.Pipe()
+	pwreader, pw := io.Pipe()
+	if err := p.Open(&testRwc{pr, pw, pr, t}, true); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	go io.Copy(ioutil.Discard, pwreader)
+
+	if s := p.pingState(); s != pluginPingStateOK {
+		t.Fatalf("Should be monitored from the start but %q", s)
+	}
+
+	// The plugin never answers
+	p.wg.Add(1)
+	go p.pingRoutine(10*time.Millisecond, 2, PluginPingActionNotify)
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case m := <-cv.Evch:
+			if m.Domain != DomainUI || m.Command != CommUINotification {
+				continue
+			}
+			if s := p.pingState(); s != pluginPingStateUnresponsive {
+				t.Fatalf("Should be %s but %s", pluginPingStateUnresponsive, s)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("The plugin never answering pings was not detected (%s)", p.pingState())
+		}
+	}
+}
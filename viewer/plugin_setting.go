@@ -0,0 +1,126 @@
+package viewer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Types of PluginSetting
+const (
+	PluginSettingTypeString = "string"
+	PluginSettingTypeInt    = "int"
+	PluginSettingTypeFloat  = "float"
+	PluginSettingTypeBool   = "bool"
+	PluginSettingTypeSelect = "select" // One of Options
+)
+
+// A PluginSetting is a setting item of a plugin declared in plugin.yml.
+// UIs can render and edit them through Plug.Settings commands.
+type PluginSetting struct {
+	Key         string      `yaml:"key"         json:"key"`
+	Type        string      `yaml:"type"        json:"type"`
+	Default     interface{} `yaml:"default"     json:"default"`
+	Description string      `yaml:"description" json:"description"`
+	Options     []string    `yaml:"options"     json:"options,omitempty"`
+}
+
+// validate checks the declaration.
+func (ps *PluginSetting) validate() error {
+	if ps.Key == "" {
+		return fmt.Errorf("key of a setting is empty")
+	}
+	switch ps.Type {
+	case PluginSettingTypeString, PluginSettingTypeInt, PluginSettingTypeFloat, PluginSettingTypeBool:
+	case PluginSettingTypeSelect:
+		if len(ps.Options) == 0 {
+			return fmt.Errorf("setting \"%s\" : options are empty", ps.Key)
+		}
+	default:
+		return fmt.Errorf("setting \"%s\" : invalid type \"%s\"", ps.Key, ps.Type)
+	}
+	if ps.Default != nil {
+		if err := ps.checkValue(ps.Default); err != nil {
+			return fmt.Errorf("setting \"%s\" : default : %s", ps.Key, err)
+		}
+	}
+	return nil
+}
+
+// checkValue checks whether the value decoded from YAML or JSON fits the type.
+func (ps *PluginSetting) checkValue(v interface{}) error {
+	ok := false
+	switch ps.Type {
+	case PluginSettingTypeString:
+		_, ok = v.(string)
+	case PluginSettingTypeBool:
+		_, ok = v.(bool)
+	case PluginSettingTypeInt:
+		switch n := v.(type) {
+		case int, int64, uint64:
+			ok = true
+		case float64:
+			ok = n == math.Trunc(n)
+		}
+	case PluginSettingTypeFloat:
+		switch v.(type) {
+		case int, int64, uint64, float64:
+			ok = true
+		}
+	case PluginSettingTypeSelect:
+		s, isstr := v.(string)
+		if isstr {
+			for _, o := range ps.Options {
+				if s == o {
+					ok = true
+				}
+			}
+			if !ok {
+				return fmt.Errorf("\"%s\" is not in the options %v", s, ps.Options)
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("the value %v is not %s", v, ps.Type)
+	}
+	return nil
+}
+
+// checkJSONValue checks the JSON value fits the type.
+func (ps *PluginSetting) checkJSONValue(raw json.RawMessage) error {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	return ps.checkValue(v)
+}
+
+// Setting returns the setting item with the key.
+func (pl *Plugin) Setting(key string) (*PluginSetting, bool) {
+	for i := range pl.Settings {
+		if pl.Settings[i].Key == key {
+			return &pl.Settings[i], true
+		}
+	}
+	return nil, false
+}
+
+// settingValues returns current values of the settings of the plugin.
+// Default values are used for settings which is not set.
+func (pl *Plugin) settingValues(s *pluginStorage) (map[string]json.RawMessage, error) {
+	vs := make(map[string]json.RawMessage)
+	for _, ps := range pl.Settings {
+		v, found, err := s.Get(pluginStorageNsSettings, pl.Name, ps.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			v, err = json.Marshal(ps.Default)
+			if err != nil {
+				return nil, err
+			}
+		}
+		vs[ps.Key] = v
+	}
+	return vs, nil
+}
@@ -0,0 +1,105 @@
+package viewer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Namespaces in pluginStorage
+const (
+	pluginStorageNsData        = "data"
+	pluginStorageNsSettings    = "settings"
+	pluginStorageNsPermissions = "permissions"
+)
+
+// A pluginStorage is a key-value store for plugins.
+// Keys are namespaced by the plugin name, so plugins can't access data of other plugins.
+type pluginStorage struct {
+	db *leveldb.DB
+}
+
+func newPluginStorage(dirname string) (*pluginStorage, error) {
+	db, err := leveldb.OpenFile(dirname, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginStorage{db}, nil
+}
+
+// newMemoryPluginStorage makes a pluginStorage in memory, which is lost when closed.
+func newMemoryPluginStorage() *pluginStorage {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return &pluginStorage{db}
+}
+
+func pluginStorageKey(ns, plug, key string) []byte {
+	return []byte(ns + "\x00" + plug + "\x00" + key)
+}
+
+// Get returns the value of the key.  found is false if the key doesn't exist.
+func (s *pluginStorage) Get(ns, plug, key string) (v json.RawMessage, found bool, err error) {
+	b, err := s.db.Get(pluginStorageKey(ns, plug, key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return json.RawMessage(b), true, nil
+}
+
+// Set sets the value of the key.  The value must be a valid JSON.
+func (s *pluginStorage) Set(ns, plug, key string, v json.RawMessage) error {
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	if !json.Valid(v) {
+		return fmt.Errorf("the value of \"%s\" is not a valid JSON", key)
+	}
+	return s.db.Put(pluginStorageKey(ns, plug, key), v, nil)
+}
+
+// Delete deletes the key.  It is not an error if the key doesn't exist.
+func (s *pluginStorage) Delete(ns, plug, key string) error {
+	return s.db.Delete(pluginStorageKey(ns, plug, key), nil)
+}
+
+// DeleteAll deletes all keys of the plugin in the namespace.
+func (s *pluginStorage) DeleteAll(ns, plug string) error {
+	keys, err := s.List(ns, plug, "")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.Delete(ns, plug, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the keys which have the prefix.
+func (s *pluginStorage) List(ns, plug, prefix string) ([]string, error) {
+	base := string(pluginStorageKey(ns, plug, ""))
+	it := s.db.NewIterator(util.BytesPrefix([]byte(base+prefix)), nil)
+	defer it.Release()
+
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, strings.TrimPrefix(string(it.Key()), base))
+	}
+	return keys, it.Error()
+}
+
+// Close closes the storage.
+func (s *pluginStorage) Close() error {
+	return s.db.Close()
+}
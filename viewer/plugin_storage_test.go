@@ -0,0 +1,105 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPluginStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	s, err := newPluginStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, k := range []string{"a1", "a2", "b"} {
+		if err := s.Set(pluginStorageNsData, "p1", k, json.RawMessage(`"`+k+`"`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set(pluginStorageNsData, "p2", "a3", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(pluginStorageNsData, "p1", "c", json.RawMessage(`{`)); err == nil {
+		t.Fatal("Setting an invalid JSON should fail")
+	}
+
+	keys, err := s.List(pluginStorageNsData, "p1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a1", "a2"}) {
+		t.Fatalf("Should be [a1 a2] but %v", keys)
+	}
+
+	v, found, err := s.Get(pluginStorageNsData, "p2", "a1")
+	if err != nil || found {
+		t.Fatalf("Other plugin's key should not be found : %s %v", v, err)
+	}
+	if _, found, _ = s.Get(pluginStorageNsSettings, "p1", "a1"); found {
+		t.Fatal("Key in other namespace should not be found")
+	}
+
+	if err := s.Delete(pluginStorageNsData, "p1", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	v, found, err = s.Get(pluginStorageNsData, "p1", "a2")
+	if err != nil || !found || string(v) != `"a2"` {
+		t.Fatalf("Should be \"a2\" but %s %v %v", v, found, err)
+	}
+	if _, found, _ = s.Get(pluginStorageNsData, "p1", "a1"); found {
+		t.Fatal("Deleted key should not be found")
+	}
+
+	if err := s.DeleteAll(pluginStorageNsData, "p1"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = s.List(pluginStorageNsData, "p1", ""); err != nil || len(keys) != 0 {
+		t.Fatalf("All keys should be deleted but %v %v", keys, err)
+	}
+	if _, found, _ = s.Get(pluginStorageNsData, "p2", "a3"); !found {
+		t.Fatal("Keys of other plugins should be kept")
+	}
+}
+
+func TestPluginSettingCheckValue(t *testing.T) {
+	tests := []struct {
+		ps    PluginSetting
+		value string
+		ok    bool
+	}{
+		{PluginSetting{Key: "a", Type: PluginSettingTypeString}, `"s"`, true},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeString}, `1`, false},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeInt}, `1`, true},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeInt}, `1.5`, false},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeFloat}, `1.5`, true},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeBool}, `true`, true},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeSelect, Options: []string{"x", "y"}}, `"y"`, true},
+		{PluginSetting{Key: "a", Type: PluginSettingTypeSelect, Options: []string{"x", "y"}}, `"z"`, false},
+	}
+	for _, test := range tests {
+		err := test.ps.checkJSONValue(json.RawMessage(test.value))
+		if (err == nil) != test.ok {
+			t.Errorf("%v %s : %v", test.ps, test.value, err)
+		}
+	}
+}
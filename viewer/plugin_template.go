@@ -0,0 +1,378 @@
+package viewer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// A pluginLangTemplate is a skeleton of a plugin written in a language.
+type pluginLangTemplate struct {
+	file   string
+	perm   os.FileMode
+	exec   []string // "{{port}}" and "{{no}}" are appended in TCP
+	source string
+}
+
+// pluginTemplateData is passed to the templates of skeletons.
+// Templates use "[[" and "]]" as delimiters not to conflict with placeholders in exec.
+type pluginTemplateData struct {
+	Name string
+	TCP  bool
+
+	DomainDirect, DomainQuery, DomainComment string
+	CommDirectNo, CommQueryLogPrint          string
+	CommCommentGot                           string
+}
+
+// pluginTemplateNameRe matches names which can be put into the skeletons without escaping.
+var pluginTemplateNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// pluginLangTemplates has skeletons for each language.
+// Each skeleton performs the handshake, logs via Log.Print, and echoes comments to the log.
+var pluginLangTemplates = map[string]pluginLangTemplate{
+	"go": {
+		file: "main.go",
+		perm: 0644,
+		exec: []string{"go", "run", "{{path}}/main.go"},
+		source: `// [[.Name]] is a Nagome plugin.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+[[- if .TCP]]
+	"net"
+[[- end]]
+	"os"
+[[- if .TCP]]
+	"strconv"
+[[- end]]
+)
+
+type message struct {
+	Domain  string          ` + "`json:\"domain\"`" + `
+	Command string          ` + "`json:\"command\"`" + `
+	Content json.RawMessage ` + "`json:\"content,omitempty\"`" + `
+}
+
+func main() {
+	var rw io.ReadWriter
+[[- if .TCP]]
+	if len(os.Args) < 3 {
+		log.Fatalln("usage : [[.Name]] port no")
+	}
+	no, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", os.Args[1]))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer conn.Close()
+	rw = conn
+[[- else]]
+	rw = struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+[[- end]]
+
+	enc := json.NewEncoder(rw)
+	send := func(dom, com string, ct interface{}) {
+		b, err := json.Marshal(ct)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := enc.Encode(message{dom, com, b}); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	logPrint := func(text string) {
+		send("[[.DomainQuery]]", "[[.CommQueryLogPrint]]", map[string]string{"text": text})
+	}
+[[- if .TCP]]
+
+	send("[[.DomainDirect]]", "[[.CommDirectNo]]", map[string]int{"no": no})
+[[- end]]
+	logPrint("[[.Name]] started")
+
+	dec := json.NewDecoder(rw)
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return
+		}
+		if m.Domain == "[[.DomainComment]]" && m.Command == "[[.CommCommentGot]]" {
+			var ct struct {
+				Comment string ` + "`json:\"comment\"`" + `
+			}
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				log.Println(err)
+				continue
+			}
+			logPrint("comment : " + ct.Comment)
+		}
+	}
+}
+`,
+	},
+	"python": {
+		file: "main.py",
+		perm: 0755,
+		exec: []string{"python3", "{{path}}/main.py"},
+		source: `#!/usr/bin/env python3
+"""[[.Name]] is a Nagome plugin."""
+import json
+import socket
+import sys
+
+
+def main():
+[[- if .TCP]]
+    port, no = int(sys.argv[1]), int(sys.argv[2])
+    sock = socket.create_connection(("localhost", port))
+    rf = sock.makefile("r", encoding="utf-8")
+    wf = sock.makefile("w", encoding="utf-8")
+[[- else]]
+    rf, wf = sys.stdin, sys.stdout
+[[- end]]
+
+    def send(domain, command, content=None):
+        m = {"domain": domain, "command": command}
+        if content is not None:
+            m["content"] = content
+        wf.write(json.dumps(m) + "\n")
+        wf.flush()
+
+    def log(text):
+        send("[[.DomainQuery]]", "[[.CommQueryLogPrint]]", {"text": text})
+[[if .TCP]]
+    send("[[.DomainDirect]]", "[[.CommDirectNo]]", {"no": no})
+[[- end]]
+    log("[[.Name]] started")
+
+    for line in rf:
+        m = json.loads(line)
+        if m["domain"] == "[[.DomainComment]]" and m["command"] == "[[.CommCommentGot]]":
+            log("comment : " + m["content"]["comment"])
+
+
+if __name__ == "__main__":
+    main()
+`,
+	},
+	"ruby": {
+		file: "main.rb",
+		perm: 0755,
+		exec: []string{"ruby", "{{path}}/main.rb"},
+		source: `#!/usr/bin/env ruby
+# [[.Name]] is a Nagome plugin.
+require 'json'
+require 'socket'
+[[if .TCP]]
+port, no = ARGV[0].to_i, ARGV[1].to_i
+rio = wio = TCPSocket.new('localhost', port)
+[[- else]]
+rio, wio = $stdin, $stdout
+[[- end]]
+wio.sync = true
+
+def send_message(wio, domain, command, content = nil)
+  m = { domain: domain, command: command }
+  m[:content] = content unless content.nil?
+  wio.puts(m.to_json)
+end
+
+def log(wio, text)
+  send_message(wio, '[[.DomainQuery]]', '[[.CommQueryLogPrint]]', { text: text })
+end
+[[if .TCP]]
+send_message(wio, '[[.DomainDirect]]', '[[.CommDirectNo]]', { no: no })
+[[- end]]
+log(wio, '[[.Name]] started')
+
+rio.each_line do |line|
+  m = JSON.parse(line)
+  if m['domain'] == '[[.DomainComment]]' && m['command'] == '[[.CommCommentGot]]'
+    log(wio, 'comment : ' + m['content']['comment'])
+  end
+end
+`,
+	},
+	"node": {
+		file: "main.js",
+		perm: 0644,
+		exec: []string{"node", "{{path}}/main.js"},
+		source: `// [[.Name]] is a Nagome plugin.
+'use strict';
+const net = require('net');
+const readline = require('readline');
+[[if .TCP]]
+const [port, no] = process.argv.slice(2).map(Number);
+const input = net.connect(port, 'localhost');
+const output = input;
+[[- else]]
+const input = process.stdin;
+const output = process.stdout;
+[[- end]]
+
+function send(domain, command, content) {
+  output.write(JSON.stringify({ domain, command, content }) + '\n');
+}
+
+function log(text) {
+  send('[[.DomainQuery]]', '[[.CommQueryLogPrint]]', { text });
+}
+[[if .TCP]]
+send('[[.DomainDirect]]', '[[.CommDirectNo]]', { no });
+[[- end]]
+log('[[.Name]] started');
+
+readline.createInterface({ input }).on('line', (line) => {
+  const m = JSON.parse(line);
+  if (m.domain === '[[.DomainComment]]' && m.command === '[[.CommCommentGot]]') {
+    log('comment : ' + m.content.comment);
+  }
+});
+`,
+	},
+	"shell": {
+		file: "main.sh",
+		perm: 0755,
+		exec: []string{"bash", "{{path}}/main.sh"},
+		source: `#!/bin/bash
+# [[.Name]] is a Nagome plugin.
+# Messages are handled as raw lines, so use other languages for serious plugins.
+[[if .TCP]]
+exec 3<>"/dev/tcp/localhost/$1"
+IN=3
+OUT=3
+[[- else]]
+IN=0
+OUT=1
+[[- end]]
+
+send() {
+	printf '%s\n' "$1" >&"$OUT"
+}
+
+# The argument must be escaped as a JSON string.
+log() {
+	send '{"domain":"[[.DomainQuery]]","command":"[[.CommQueryLogPrint]]","content":{"text":"'"$1"'"}}'
+}
+[[if .TCP]]
+send '{"domain":"[[.DomainDirect]]","command":"[[.CommDirectNo]]","content":{"no":'"$2"'}}'
+[[- end]]
+log "[[.Name]] started"
+
+while IFS= read -r -u "$IN" line; do
+	case "$line" in
+	*'"domain":"[[.DomainComment]]","command":"[[.CommCommentGot]]"'*)
+		comment=$(printf '%s' "$line" | sed -n 's/.*"comment":"\(\([^"\\]\|\\.\)*\)".*/\1/p')
+		log "comment : $comment"
+		;;
+	esac
+done
+`,
+	},
+}
+
+// PluginTemplateLangs returns names of the languages of plugin templates.
+func PluginTemplateLangs() []string {
+	ls := make([]string, 0, len(pluginLangTemplates))
+	for l := range pluginLangTemplates {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// A PluginTemplateOption is options for generating plugin template.
+type PluginTemplateOption struct {
+	Name      string
+	Lang      string
+	Method    string
+	Subscribe []string
+}
+
+// generatePluginTemplate makes a plugin directory which has plugin.yml and a runnable skeleton into pluginPath.
+// It returns the path to the directory.
+func generatePluginTemplate(opt PluginTemplateOption, pluginPath string) (string, error) {
+	if !isValidPluginName(opt.Name) {
+		return "", fmt.Errorf("invalid plugin name \"%s\"", opt.Name)
+	}
+	if !pluginTemplateNameRe.MatchString(opt.Name) {
+		return "", fmt.Errorf("plugin name \"%s\" must consist of alphabets, digits, \"_\" and \"-\"", opt.Name)
+	}
+	lt, ok := pluginLangTemplates[opt.Lang]
+	if !ok {
+		return "", fmt.Errorf("unknown language \"%s\" (select from %s)", opt.Lang, strings.Join(PluginTemplateLangs(), ", "))
+	}
+	if opt.Method != pluginMethodTCP && opt.Method != pluginMethodStd {
+		return "", fmt.Errorf("unknown method \"%s\" (select from %s, %s)", opt.Method, pluginMethodStd, pluginMethodTCP)
+	}
+
+	p := filepath.Join(pluginPath, opt.Name)
+
+	// check if the directory already exists
+	_, err := os.Stat(p)
+	if err == nil {
+		return "", fmt.Errorf("same name directory is already exists")
+	}
+
+	if err := os.MkdirAll(p, 0777); err != nil {
+		return "", fmt.Errorf("Could not make save directory : %s", err)
+	}
+
+	tmpl, err := template.New(opt.Lang).Delims("[[", "]]").Parse(lt.source)
+	if err != nil {
+		return "", err
+	}
+	var src strings.Builder
+	err = tmpl.Execute(&src, pluginTemplateData{
+		Name:              opt.Name,
+		TCP:               opt.Method == pluginMethodTCP,
+		DomainDirect:      DomainDirect,
+		DomainQuery:       DomainQuery,
+		DomainComment:     DomainComment,
+		CommDirectNo:      CommDirectNo,
+		CommQueryLogPrint: CommQueryLogPrint,
+		CommCommentGot:    CommCommentGot,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(filepath.Join(p, lt.file), []byte(src.String()), lt.perm)
+	if err != nil {
+		return "", fmt.Errorf("failed to save file : %s", err)
+	}
+
+	exec := append([]string{}, lt.exec...)
+	if opt.Method == pluginMethodTCP {
+		exec = append(exec, "{{port}}", "{{no}}")
+	}
+	pl := Plugin{
+		Name:      opt.Name,
+		Version:   "1.0",
+		Subscribe: opt.Subscribe,
+		Method:    opt.Method,
+		Exec:      exec,
+	}
+	err = pl.Save(filepath.Join(p, pluginConfigName))
+	if err != nil {
+		return "", fmt.Errorf("failed to save file : %s", err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,90 @@
+package viewer
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGeneratePluginTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Commands to check the syntax of the skeletons.  Skipped if the command is not found.
+	checks := map[string][]string{
+		"python": {"python3", "-m", "py_compile"},
+		"ruby":   {"ruby", "-c"},
+		"node":   {"node", "--check"},
+		"shell":  {"bash", "-n"},
+	}
+
+	sub := []string{DomainNagome, DomainComment}
+	for _, lang := range PluginTemplateLangs() {
+		for _, method := range []string{pluginMethodStd, pluginMethodTCP} {
+			name := lang + "_" + method
+			p, err := generatePluginTemplate(PluginTemplateOption{name, lang, method, sub}, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var pl Plugin
+			if err := pl.Load(filepath.Join(p, pluginConfigName)); err != nil {
+				t.Fatal(err)
+			}
+			if pl.Name != name || pl.Method != method || !reflect.DeepEqual(pl.Subscribe, sub) {
+				t.Fatalf("Unexpected plugin.yml : %v %v %v", pl.Name, pl.Method, pl.Subscribe)
+			}
+			if method == pluginMethodTCP && pl.Exec[len(pl.Exec)-1] != "{{no}}" {
+				t.Fatalf("TCP plugin should take its No : %v", pl.Exec)
+			}
+
+			src := filepath.Join(p, pluginLangTemplates[lang].file)
+			if _, err := os.Stat(src); err != nil {
+				t.Fatal(err)
+			}
+			if lang == "go" {
+				if _, err := parser.ParseFile(token.NewFileSet(), src, nil, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c, ok := checks[lang]; ok {
+				if _, err := exec.LookPath(c[0]); err != nil {
+					t.Logf("%s is not checked : %v", name, err)
+					continue
+				}
+				out, err := exec.Command(c[0], append(c[1:], src)...).CombinedOutput()
+				if err != nil {
+					t.Fatalf("%s : %v\n%s", name, err, out)
+				}
+			}
+		}
+	}
+
+	_, err = generatePluginTemplate(PluginTemplateOption{"go_std", "go", pluginMethodStd, sub}, dir)
+	if err == nil {
+		t.Fatal("Should be failed because of existing directory")
+	}
+	_, err = generatePluginTemplate(PluginTemplateOption{"cobol", "cobol", pluginMethodStd, sub}, dir)
+	if err == nil {
+		t.Fatal("Should be failed because of unknown language")
+	}
+	for _, name := range []string{"", "..", "../escape", `quote"name`, "new\nline"} {
+		_, err = generatePluginTemplate(PluginTemplateOption{name, "go", pluginMethodStd, sub}, dir)
+		if err == nil {
+			t.Fatalf("Should be failed because of invalid name %q", name)
+		}
+	}
+}
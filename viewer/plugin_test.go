@@ -193,3 +193,55 @@ func TestPluginWrite(t *testing.T) {
 
 	p.Close()
 }
+
+func TestFindPlugin(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	for _, n := range []string{"main", "tts", "overlay"} {
+		p := newPlugin(cv)
+		p.Name = n
+		cv.AddPlugin(p)
+	}
+
+	one, nine := 1, 9
+	tests := []struct {
+		name string
+		no   *int
+		want string
+	}{
+		{"overlay", nil, "overlay"},
+		{"", &one, "tts"},
+		{"tts", &one, "tts"},
+		{"overlay", &one, ""},
+		{"", &nine, ""},
+		{"none", nil, ""},
+		{"", nil, ""},
+	}
+	for _, test := range tests {
+		p, err := cv.findPlugin(test.name, test.no)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s %v : Should be an error but %s", test.name, test.no, p.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v : %v", test.name, test.no, err)
+			continue
+		}
+		if p.Name != test.want {
+			t.Errorf("%s %v : Should be %s but %s", test.name, test.no, test.want, p.Name)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package viewer
 
 import (
-	"fmt"
 	"path/filepath"
 	"strings"
 	"time"
@@ -9,48 +8,41 @@ import (
 	"github.com/diginatu/nagome/nicolive"
 )
 
-const (
-	userNameAPITimesAMinute = 6
-)
-
 // ProceedNicoliveEvent is struct for proceeding nico events from nicolive packeage.
 type ProceedNicoliveEvent struct {
-	cv                  *CommentViewer
-	userDB              *nicolive.UserDB
-	userNameAPITimes    int
-	userNameAPIFastTime time.Time
+	cv          *CommentViewer
+	userDB      *nicolive.UserDB
+	userFetch   *userFetcher
+	communityID string // Community of the current broadcast
+	broadID     string // Current broadcast
 }
 
 // NewProceedNicoliveEvent makes new ProceedNicoliveEvent and returns it.
 func NewProceedNicoliveEvent(cv *CommentViewer) *ProceedNicoliveEvent {
-	udb, err := nicolive.NewUserDB(filepath.Join(cv.cli.SavePath, userDBDirName))
+	var udb *nicolive.UserDB
+	var err error
+	if cv.cli.MemoryStorage {
+		udb, err = nicolive.NewUserDBWithStorage(nicolive.NewMemoryUserStorage())
+	} else {
+		udb, err = nicolive.NewUserDB(filepath.Join(cv.cli.SavePath, userDBDirName))
+	}
+	if nerr, ok := err.(nicolive.Error); ok && nerr.Type() == nicolive.ErrDBLocked {
+		// Another Nagome is running
+		cv.cli.log.Println("user DB is kept in memory : " + err.Error())
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, "User DB",
+			"The user DB is used by another Nagome.  Users are not saved in this session.")
+		udb, err = nicolive.NewUserDBWithStorage(nicolive.NewMemoryUserStorage())
+	}
 	if err != nil {
 		cv.cli.log.Fatalln(err)
 	}
 	return &ProceedNicoliveEvent{
-		cv:     cv,
-		userDB: udb,
+		cv:        cv,
+		userDB:    udb,
+		userFetch: newUserFetcher(cv),
 	}
 }
 
-// CheckIntervalAndCreateUser returns a pointer to new struct nicolive.User with fetched user information unless it exceed the limitation of API
-func (p *ProceedNicoliveEvent) CheckIntervalAndCreateUser(id string) (*nicolive.User, error) {
-	// reset API limit
-	if time.Now().After(p.userNameAPIFastTime.Add(time.Minute)) {
-		p.userNameAPIFastTime = time.Now()
-		p.userNameAPITimes = userNameAPITimesAMinute
-	}
-	if p.userNameAPITimes > 0 {
-		u, nerr := nicolive.CreateUser(id, p.cv.Ac)
-		if nerr != nil {
-			return nil, nerr
-		}
-		p.userNameAPITimes--
-		return u, nil
-	}
-	return nil, fmt.Errorf("exceed the limit of fetching user name from web page")
-}
-
 func (p *ProceedNicoliveEvent) proceedComment(ev *nicolive.Event) {
 	cm, _ := ev.Content.(nicolive.Comment)
 	ct := CtCommentGot{
@@ -63,20 +55,61 @@ func (p *ProceedNicoliveEvent) proceedComment(ev *nicolive.Event) {
 		IsStaff:       cm.IsStaff,
 		IsAnonymity:   cm.IsAnonymity,
 		Score:         cm.Score,
+		Locale:        cm.Locale,
+		IsOverseas:    cm.IsOverseas(),
+		Mail:          cm.Mail,
+	}
+	mc := cm.MailCommand()
+	ct.MailColor = mc.Color
+	ct.MailColorHex = mc.ColorHex
+	ct.MailSize = mc.Size
+	ct.MailPosition = mc.Position
+	ct.MailFont = mc.Font
+	ct.MailDuration = mc.Duration
+	ct.Mail184 = mc.Is184
+	ct.MailFlags = mc.Flags
+
+	ngType, drop := p.cv.ng.Match(&cm)
+	if drop {
+		return
 	}
+	ct.NG = ngType
 
-	// Get user name from DB
-	u, err := p.userDB.Fetch(cm.UserID)
+	// Get user name from DB and record the comment
+	var learned, seen bool
+	u, err := p.userDB.Update(cm.UserID, func(u *nicolive.User) {
+		if cm.IsCommand {
+			return
+		}
+		if p.cv.Settings.KotehanLearn {
+			learned = p.learnKotehan(&cm, u)
+		}
+		// 184 IDs change often, so recording them only grows the DB.
+		if !u.Is184 || p.cv.Settings.History184 {
+			seen = p.seen(&cm, u, &ct)
+		}
+	})
 	if err != nil {
-		err, ok := err.(nicolive.Error)
-		if ok && err.Type() == nicolive.ErrDBUserNotFound {
-		} else {
-			p.cv.cli.log.Println(err)
+		p.cv.cli.log.Println(err)
+		learned, seen = false, false
+		u = &nicolive.User{
+			ID:         cm.UserID,
+			CreateTime: time.Now(),
+			Is184:      nicolive.Is184UserID(cm.UserID),
 		}
+	}
+
+	if p.cv.Settings.KotehanScope == KotehanScopeCommunity {
+		ct.UserName = u.NameIn(p.communityID)
 	} else {
 		ct.UserName = u.Name
-		ct.UserThumbnailURL = u.ThumbnailURL
 	}
+	ct.UserThumbnailURL = u.ThumbnailURL
+	ct.ThumbnailPath = p.cv.thumbnails.Path(u.ID, u.ThumbnailURL)
+	ct.UserNote = u.Note
+	ct.UserTags = u.Tags
+	ct.UserColor = u.Color
+	ct.UserHide = u.Hide
 
 	if cm.IsCommand {
 		ct.UserName = "Broadcaster"
@@ -84,11 +117,53 @@ func (p *ProceedNicoliveEvent) proceedComment(ev *nicolive.Event) {
 
 	ct.Comment = strings.Replace(cm.Comment, "\n", "<br>", -1)
 	p.cv.Evch <- NewMessageMust(DomainComment, CommCommentGot, ct)
+	if learned {
+		p.cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, p.cv.newCtNagomeUserUpdate(u))
+	}
+	if seen && ct.IsFirstInCommunity {
+		p.cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserFirst, CtNagomeUserFirst{
+			ID:          u.ID,
+			Name:        ct.UserName,
+			CommunityID: p.communityID,
+			No:          cm.No,
+		})
+	}
 
 	useAPI := p.cv.Settings.UserNameGet && cm.Date.After(p.cv.Cmm.ConnectedTm) && !cm.IsAnonymity && !cm.IsCommand
 	if ct.UserName == "" && useAPI {
-		p.cv.Evch <- NewMessageMust(DomainQuery, CommQueryUserFetch,
-			CtQueryUserFetch{ID: ct.UserID})
+		p.userFetch.Request(ct.UserID, false)
+	}
+}
+
+// seen records the comment in the history of the user in the community and sets fields about it to ct.
+// It returns whether the user is changed.
+func (p *ProceedNicoliveEvent) seen(cm *nicolive.Comment, u *nicolive.User, ct *CtCommentGot) bool {
+	if p.communityID == "" {
+		return false
+	}
+	c, updated := u.Seen(p.communityID, p.broadID, cm.No, cm.Date)
+	ct.IsFirstInCommunity = c.FirstBroad == p.broadID && c.FirstNo == cm.No
+	ct.IsFirstInBroad = c.Broad == p.broadID && c.BroadFirstNo == cm.No
+	if !c.PrevLastSeen.IsZero() {
+		ls := c.PrevLastSeen
+		ct.LastSeen = &ls
+	}
+	ct.CommentCount = c.Comments
+	return updated
+}
+
+func newCtNagomeBroadOpen(lv *nicolive.LiveWaku) CtNagomeBroadOpen {
+	return CtNagomeBroadOpen{
+		BroadID:     lv.BroadID,
+		Title:       lv.Stream.Title,
+		Description: lv.Stream.Description,
+		CommunityID: lv.Stream.CommunityID,
+		OwnerID:     lv.Stream.OwnerID,
+		OwnerName:   lv.Stream.OwnerName,
+		OwnerBroad:  lv.OwnerBroad,
+		OpenTime:    lv.Stream.OpenTime,
+		StartTime:   lv.Stream.StartTime,
+		EndTime:     lv.Stream.EndTime,
 	}
 }
 
@@ -102,21 +177,12 @@ func (p *ProceedNicoliveEvent) ProceedNicoEvent(ev *nicolive.Event) {
 		p.cv.Evch <- NewMessageMust(DomainUI, CommUIClearComments, nil)
 		lv := ev.Content.(*nicolive.LiveWaku)
 		p.cv.cli.log.Println(lv)
-		ct := CtNagomeBroadOpen{
-			BroadID:     lv.BroadID,
-			Title:       lv.Stream.Title,
-			Description: lv.Stream.Description,
-			CommunityID: lv.Stream.CommunityID,
-			OwnerID:     lv.Stream.OwnerID,
-			OwnerName:   lv.Stream.OwnerName,
-			OwnerBroad:  lv.OwnerBroad,
-			OpenTime:    lv.Stream.OpenTime,
-			StartTime:   lv.Stream.StartTime,
-			EndTime:     lv.Stream.EndTime,
-		}
-		p.cv.Evch <- NewMessageMust(DomainNagome, CommNagomeBroadOpen, ct)
+		p.communityID = lv.Stream.CommunityID
+		p.broadID = lv.BroadID
+		p.cv.Evch <- NewMessageMust(DomainNagome, CommNagomeBroadOpen, newCtNagomeBroadOpen(lv))
 
 	case nicolive.EventTypeCommentClose:
+		p.cv.timers.CancelAll()
 		p.cv.Evch <- NewMessageMust(DomainNagome, CommNagomeBroadClose, nil)
 
 	case nicolive.EventTypeHeartBeatGot:
@@ -0,0 +1,143 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestProceedCommentHistory(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	tm := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	open := func(broad string) {
+		lv := &nicolive.LiveWaku{BroadID: broad}
+		lv.Stream.CommunityID = "co1"
+		cv.prcdnle.ProceedNicoEvent(&nicolive.Event{Type: nicolive.EventTypeCommentOpen, Content: lv})
+		for len(cv.Evch) != 0 {
+			<-cv.Evch
+		}
+	}
+	comment := func(no int, id string, d time.Duration) (ct CtCommentGot, first *CtNagomeUserFirst) {
+		cv.prcdnle.ProceedNicoEvent(&nicolive.Event{
+			Type:    nicolive.EventTypeCommentGot,
+			Content: nicolive.Comment{No: no, UserID: id, Date: tm.Add(d)},
+		})
+		for len(cv.Evch) != 0 {
+			m := <-cv.Evch
+			switch m.Command {
+			case CommCommentGot:
+				if err := json.Unmarshal(m.Content, &ct); err != nil {
+					t.Fatal(err)
+				}
+			case CommNagomeUserFirst:
+				first = new(CtNagomeUserFirst)
+				if err := json.Unmarshal(m.Content, first); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return
+	}
+
+	open("lv1")
+	ct, first := comment(1, "1", 0)
+	if !ct.IsFirstInBroad || !ct.IsFirstInCommunity || ct.LastSeen != nil || ct.CommentCount != 1 ||
+		first == nil || first.ID != "1" || first.CommunityID != "co1" || first.No != 1 {
+		t.Fatalf("Unexpected first comment %#v %#v", ct, first)
+	}
+	ct, first = comment(2, "1", time.Minute)
+	if ct.IsFirstInBroad || ct.IsFirstInCommunity || ct.CommentCount != 2 || first != nil {
+		t.Fatalf("Unexpected second comment %#v %#v", ct, first)
+	}
+
+	// Comments sent again when reconnecting
+	open("lv1")
+	ct, first = comment(1, "1", 0)
+	if !ct.IsFirstInBroad || !ct.IsFirstInCommunity || ct.CommentCount != 2 || first != nil {
+		t.Fatalf("Unexpected comment after reconnecting %#v %#v", ct, first)
+	}
+
+	open("lv2")
+	ct, first = comment(1, "1", time.Hour)
+	if !ct.IsFirstInBroad || ct.IsFirstInCommunity || ct.LastSeen == nil || !ct.LastSeen.Equal(tm.Add(time.Minute)) ||
+		ct.CommentCount != 3 || first != nil {
+		t.Fatalf("Unexpected comment in next broadcast %#v %#v", ct, first)
+	}
+
+	// 184 users are not recorded by default
+	ct, first = comment(2, "abc", time.Hour)
+	if ct.CommentCount != 0 || first != nil {
+		t.Fatalf("Unexpected comment of 184 user %#v %#v", ct, first)
+	}
+	if _, err := cv.prcdnle.userDB.Fetch("abc"); err == nil {
+		t.Fatal("184 user should not be stored")
+	}
+	cv.Settings.History184 = true
+	ct, first = comment(3, "abc", time.Hour)
+	if !ct.IsFirstInCommunity || ct.CommentCount != 1 || first == nil {
+		t.Fatalf("Unexpected comment of 184 user %#v %#v", ct, first)
+	}
+}
+
+func TestProceedCommentMail(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	cv.prcdnle.ProceedNicoEvent(&nicolive.Event{
+		Type: nicolive.EventTypeCommentGot,
+		Content: nicolive.Comment{No: 1, UserID: "abc", Comment: "hello",
+			Mail: "184 red big ue @3 ender", Locale: "en-us", IsAnonymity: true},
+	})
+	var ct CtCommentGot
+	if err := json.Unmarshal((<-cv.Evch).Content, &ct); err != nil {
+		t.Fatal(err)
+	}
+	if ct.Mail != "184 red big ue @3 ender" || ct.MailColor != "red" || ct.MailColorHex != "#FF0000" ||
+		ct.MailSize != "big" || ct.MailPosition != "ue" || ct.MailDuration != 3 || !ct.Mail184 ||
+		len(ct.MailFlags) != 1 || ct.MailFlags[0] != "ender" {
+		t.Fatalf("Unexpected mail commands %#v", ct)
+	}
+	if ct.Locale != "en-us" || !ct.IsOverseas {
+		t.Fatalf("Unexpected locale %#v", ct)
+	}
+}
@@ -3,6 +3,7 @@ package viewer
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"path/filepath"
 	"regexp"
@@ -125,6 +126,12 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 			}
 
 			cv.Settings = SettingsSlot(ct)
+			cv.backlog.SetSize(cv.Settings.CommentBacklogSize)
+			cv.thumbnails.Set(cv.Settings.Thumbnail)
+			if err := cv.ng.Set(&cv.Settings.NG); err != nil {
+				cv.cli.log.Println(err)
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "NG filter", err.Error())
+			}
 			for _, p := range cv.Pgns {
 				p.SetState(!cv.Settings.PluginDisable[p.Name])
 			}
@@ -153,6 +160,117 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 			}
 			cv.Settings.PluginDisable[pl.Name] = !ct.Enable
 
+		case CommQueryPlugRestart:
+			var ct CtQueryPlugRestart
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+
+			pl, err := cv.Plugin(ct.No)
+			if err != nil {
+				return err
+			}
+			if err := cv.restartPlugin(pl); err != nil {
+				return nicolive.ErrFromStdErr(err)
+			}
+			cv.cli.log.Printf("restarting plugin [%s]\n", pl.Name)
+
+		case CommQueryPlugInstall:
+			var ct CtQueryPlugInstall
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+
+			ip, oldv, err := installPlugin(ct.Path, filepath.Join(cv.cli.SavePath, pluginDirName), ct.Upgrade)
+			if err != nil {
+				cv.cli.log.Println(err)
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Installing the plugin failed", err.Error())
+				return nil
+			}
+			desc := fmt.Sprintf("%s %s is installed.  It will be loaded at next start.", ip.Name, ip.Version)
+			if ct.Upgrade && oldv != "" {
+				desc = fmt.Sprintf("%s is upgraded from %s to %s.  It will be loaded at next start.", ip.Name, oldv, ip.Version)
+			}
+			cv.EmitEvNewNotification(CtUINotificationTypeInfo, "Plugin installed", desc)
+
+		case CommQueryPlugUninstall:
+			var ct CtQueryPlugUninstall
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+
+			pluginPath := filepath.Join(cv.cli.SavePath, pluginDirName)
+			ip, err := findInstalledPlugin(pluginPath, ct.Name)
+			if err == nil {
+				// Stop the running plugin before removing its files
+				for _, p := range cv.Pgns {
+					if p.Name == ip.Name && !p.IsMain() {
+						if err = p.stop(pluginStopTimeout); err != nil {
+							break
+						}
+					}
+				}
+			}
+			if err == nil {
+				ip, err = uninstallPlugin(ip.Name, pluginPath)
+			}
+			if err != nil {
+				cv.cli.log.Println(err)
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Uninstalling the plugin failed", err.Error())
+				return nil
+			}
+			// Permissions are asked again if the plugin is installed again.
+			if err := cv.plugStrg.DeleteAll(pluginStorageNsPermissions, ip.Name); err != nil {
+				cv.cli.log.Println(err)
+			}
+			cv.EmitEvNewNotification(CtUINotificationTypeInfo, "Plugin uninstalled", ip.Name+" is uninstalled.")
+
+		case CommQueryPlugSettingsSet:
+			var ct CtQueryPlugSettingsSet
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+
+			var pl *Plugin
+			for _, p := range cv.Pgns {
+				if p.Name == ct.Name {
+					pl = p
+				}
+			}
+			if pl == nil {
+				return nicolive.MakeError(nicolive.ErrOther, "no plugin named "+ct.Name)
+			}
+			ps, ok := pl.Setting(ct.Key)
+			if !ok {
+				return nicolive.MakeError(nicolive.ErrOther, fmt.Sprintf("plugin [%s] has no setting \"%s\"", ct.Name, ct.Key))
+			}
+			if err := ps.checkJSONValue(ct.Value); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, fmt.Sprintf("setting \"%s\" : %s", ct.Key, err))
+			}
+			if err := cv.plugStrg.Set(pluginStorageNsSettings, pl.Name, ct.Key, ct.Value); err != nil {
+				return nicolive.ErrFromStdErr(err)
+			}
+			pl.WriteMess(NewMessageMust(DomainDirectngm, CommDirectngmPlugSettingsChanged,
+				CtDirectngmPlugSettingsChanged{ct.Key, ct.Value}))
+
+		case CommQueryPlugPermit:
+			if m.origin > 0 {
+				return nicolive.MakeError(nicolive.ErrOther, "only the main plugin can grant permissions")
+			}
+			var ct CtQueryPlugPermit
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+
+			pl, err := cv.Plugin(ct.No)
+			if err != nil {
+				return err
+			}
+			if err := cv.grantPermissions(pl, ct.Permissions, ct.Allow); err != nil {
+				return nicolive.ErrFromStdErr(err)
+			}
+			cv.cli.log.Printf("permissions of plugin [%s] : %v\n", pl.Name, pl.Granted)
+
 		case CommQueryUserSet:
 			var ct nicolive.User // CtQueryUserSet
 			if err := json.Unmarshal(m.Content, &ct); err != nil {
@@ -166,7 +284,7 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 				return err
 			}
 
-			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, CtNagomeUserUpdate(ct))
+			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, cv.newCtNagomeUserUpdate(&ct))
 
 		case CommQueryUserSetName:
 			var ct CtQueryUserSetName
@@ -180,29 +298,15 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 				return nicolive.MakeError(nicolive.ErrOther, "format error : Name is empty")
 			}
 
-			user, err := cv.prcdnle.userDB.Fetch(ct.ID)
-			if err != nil {
-				nerr, ok := err.(nicolive.Error)
-				if ok && nerr.Type() == nicolive.ErrDBUserNotFound {
-					user, err = cv.prcdnle.CheckIntervalAndCreateUser(ct.ID)
-					if err != nil {
-						cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Storing the user name failed", "DB error")
-						return nicolive.MakeError(nicolive.ErrOther, "Storing the user name failed: DB error : "+err.Error())
-					}
-				} else {
-					cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Storing the user name failed", "DB error")
-					return nicolive.MakeError(nicolive.ErrOther, "Storing the user name failed: DB error : "+err.Error())
-				}
-			}
-
-			user.Name = ct.Name
-			err = cv.prcdnle.userDB.Store(user)
+			user, err := cv.prcdnle.userDB.Update(ct.ID, func(u *nicolive.User) {
+				u.Name = ct.Name
+			})
 			if err != nil {
 				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Storing the user name failed", "DB error : "+err.Error())
 				return err
 			}
 
-			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, CtNagomeUserUpdate(*user))
+			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, cv.newCtNagomeUserUpdate(user))
 
 		case CommQueryUserDelete:
 			var ct CtQueryUserDelete
@@ -217,13 +321,13 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 				return err
 			}
 
-			usr := CtNagomeUserUpdate{
+			usr := CtNagomeUserUpdate{User: nicolive.User{
 				ID:           ct.ID,
 				Name:         "",
 				CreateTime:   time.Unix(0, 0),
 				Is184:        nicolive.Is184UserID(ct.ID),
 				ThumbnailURL: "",
-			}
+			}}
 			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, usr)
 
 		case CommQueryUserFetch:
@@ -233,34 +337,90 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
 			}
 
-			user, err := cv.prcdnle.CheckIntervalAndCreateUser(ct.ID)
-			if err != nil {
-				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Removing the user info failed", "DB error : "+err.Error())
-				return err
+			if !cv.prcdnle.userFetch.Request(ct.ID, true) {
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Fetching the user info failed", "184 users don't have names")
+				return nicolive.MakeError(nicolive.ErrOther, "format error : can't fetch 184 user "+ct.ID)
 			}
 
-			userCurrent, err := cv.prcdnle.userDB.Fetch(ct.ID)
-			if err != nil {
-				nerr, ok := err.(nicolive.Error)
-				if ok && nerr.Type() == nicolive.ErrDBUserNotFound {
-					// If the user was not in the DB
-					userCurrent = user
-				} else {
-					cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Removing the user info failed", "DB error : "+err.Error())
-					return err
-				}
-			} else {
-				userCurrent.Name = user.Name
-				userCurrent.ThumbnailURL = user.ThumbnailURL
+		case CommQueryUserSetNote:
+			var ct CtQueryUserSetNote
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			return cv.updateUser(ct.ID, "Storing the user note failed", func(u *nicolive.User) {
+				u.Note = ct.Note
+			})
+
+		case CommQueryUserSetTags:
+			var ct CtQueryUserSetTags
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			return cv.updateUser(ct.ID, "Storing the user tags failed", func(u *nicolive.User) {
+				u.Tags = normalizeTags(ct.Tags)
+			})
+
+		case CommQueryUserSetColor:
+			var ct CtQueryUserSetColor
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			if !isValidUserColor(ct.Color) {
+				return nicolive.MakeError(nicolive.ErrOther, "format error : color should be #rrggbb : "+ct.Color)
+			}
+			return cv.updateUser(ct.ID, "Storing the user color failed", func(u *nicolive.User) {
+				u.Color = ct.Color
+			})
+
+		case CommQueryUserSetHide:
+			var ct CtQueryUserSetHide
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
 			}
+			return cv.updateUser(ct.ID, "Storing the user hide flag failed", func(u *nicolive.User) {
+				u.Hide = ct.Hide
+			})
 
-			err = cv.prcdnle.userDB.Store(userCurrent)
+		case CommQueryUserBackup:
+			ct := CtQueryUserBackup{Format: UserFormatJSON}
+			if m.Content != nil {
+				if err := json.Unmarshal(m.Content, &ct); err != nil {
+					return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+				}
+			}
+			if ct.Format == "" {
+				ct.Format = UserFormatJSON
+			}
+			path, err := backupUsers(cv.prcdnle.userDB, cv.cli.SavePath, ct.Format, time.Now())
 			if err != nil {
-				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Removing the user info failed", "DB error : "+err.Error())
-				return err
+				cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Backup of the user DB failed", err.Error())
+				return nicolive.ErrFromStdErr(err)
 			}
+			cv.EmitEvNewNotification(CtUINotificationTypeInfo, "Backup of the user DB", "Saved to "+path)
 
-			cv.Evch <- NewMessageMust(DomainNagome, CommNagomeUserUpdate, CtNagomeUserUpdate(*userCurrent))
+		case CommQueryNGAdd:
+			var ct CtQueryNGAdd
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			if err := addNG(&cv.Settings.NG, ct.Type, ct.Value); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, err.Error())
+			}
+			if err := cv.ng.Set(&cv.Settings.NG); err != nil {
+				return nicolive.ErrFromStdErr(err)
+			}
+
+		case CommQueryNGRemove:
+			var ct CtQueryNGRemove
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			if err := removeNG(&cv.Settings.NG, ct.Type, ct.Value); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, err.Error())
+			}
+			if err := cv.ng.Set(&cv.Settings.NG); err != nil {
+				return nicolive.ErrFromStdErr(err)
+			}
 
 		case CommDirectUserGet:
 			var ct CtDirectUserGet
@@ -281,6 +441,28 @@ func processNagomeMessage(cv *CommentViewer, m *Message) error {
 			return nicolive.MakeError(nicolive.ErrOther, "Message : invalid query command : "+m.Command)
 		}
 
+	case DomainComment:
+		switch m.Command {
+		case CommCommentGot:
+			var ct CtCommentGot
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			cv.backlog.Add(ct)
+		}
+
+	case DomainNagome:
+		switch m.Command {
+		case CommNagomeBroadOpen, CommNagomeBroadClose:
+			cv.backlog.Clear()
+		case CommNagomeBroadInfo:
+			var ct CtNagomeBroadInfo
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+			cv.brdInfo = &ct
+		}
+
 	case DomainAntenna:
 		switch m.Command {
 		case CommAntennaGot:
@@ -339,6 +521,7 @@ func processQueryBroadConnect(cv *CommentViewer, m *Message) error {
 	}
 
 	cv.Disconnect()
+	cv.brdInfo = nil
 
 	cv.Lw = lw
 	cv.Cmm, err = nicolive.CommentConnect(context.TODO(), *cv.Lw, cv.prcdnle)
@@ -371,11 +554,24 @@ func processDirectMessage(cv *CommentViewer, m *Message) error {
 			return nicolive.ErrFromStdErr(err)
 		}
 	case CommDirectPlugList:
+		for _, p := range cv.Pgns {
+			p.PingState = p.pingState()
+		}
 		c := CtDirectngmPlugList{&cv.Pgns}
 		t, err = NewMessage(DomainDirectngm, CommDirectngmPlugList, c)
 		if err != nil {
 			return nicolive.ErrFromStdErr(err)
 		}
+	case CommDirectPlugInstalled:
+		ps, errs := listInstalledPlugins(filepath.Join(cv.cli.SavePath, pluginDirName))
+		c := CtDirectngmPlugInstalled{Plugins: ps}
+		for _, e := range errs {
+			c.Errors = append(c.Errors, e.Error())
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmPlugInstalled, c)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
 	case CommDirectSettingsCurrent:
 		t, err = NewMessage(DomainDirectngm, CommDirectngmSettingsCurrent, CtDirectngmSettingsCurrent(cv.Settings))
 		if err != nil {
@@ -386,6 +582,223 @@ func processDirectMessage(cv *CommentViewer, m *Message) error {
 		if err != nil {
 			return nicolive.ErrFromStdErr(err)
 		}
+	case CommDirectUserSearch:
+		var ct CtDirectUserSearch
+		if m.Content != nil {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		if ct.Limit <= 0 {
+			ct.Limit = userSearchDefaultLimit
+		}
+		var us []*nicolive.User
+		var next string
+		if ct.Name != "" {
+			us, next, err = cv.prcdnle.userDB.ListByName(ct.Name, ct.NameSubstr, ct.Cursor, ct.Limit, ct.match)
+		} else {
+			us, next, err = cv.prcdnle.userDB.List(ct.Cursor, ct.Limit, ct.match)
+		}
+		if err != nil {
+			return err
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmUserSearch, CtDirectngmUserSearch{Users: us, Next: next})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectUserList:
+		var ct CtDirectUserList
+		if m.Content != nil {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		if ct.Limit <= 0 {
+			ct.Limit = userSearchDefaultLimit
+		}
+		us, next, err := cv.prcdnle.userDB.List(ct.Cursor, ct.Limit, nil)
+		if err != nil {
+			return err
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmUserList, CtDirectngmUserList{Users: us, Next: next})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectNGList:
+		t, err = NewMessage(DomainDirectngm, CommDirectngmNGList, CtDirectngmNGList(cv.Settings.NG))
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectPlugStorageGet:
+		var ct CtDirectPlugStorageGet
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		v, found, err := cv.plugStrg.Get(pluginStorageNsData, cv.PluginName(m.plgno), ct.Key)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmPlugStorageGet, CtDirectngmPlugStorageGet{ct.Key, v, found})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectPlugStorageSet:
+		var ct CtDirectPlugStorageSet
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		if err := cv.plugStrg.Set(pluginStorageNsData, cv.PluginName(m.plgno), ct.Key, ct.Value); err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		return nil
+	case CommDirectPlugStorageDelete:
+		var ct CtDirectPlugStorageDelete
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		if err := cv.plugStrg.Delete(pluginStorageNsData, cv.PluginName(m.plgno), ct.Key); err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		return nil
+	case CommDirectPlugStorageList:
+		var ct CtDirectPlugStorageList
+		if len(m.Content) != 0 {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		keys, err := cv.plugStrg.List(pluginStorageNsData, cv.PluginName(m.plgno), ct.Prefix)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmPlugStorageList, CtDirectngmPlugStorageList{keys})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectPlugSettingsGet:
+		var ct CtDirectPlugSettingsGet
+		if len(m.Content) != 0 {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		if ct.Name == "" {
+			ct.Name = cv.PluginName(m.plgno)
+		}
+		// Settings of other plugins may have secrets.
+		if sender, err := cv.Plugin(m.plgno); err == nil && sender.Name != ct.Name && !sender.HasPermission(PluginPermPlugin) {
+			return nicolive.MakeError(nicolive.ErrOther,
+				fmt.Sprintf("permission \"%s\" is needed to get settings of other plugins", PluginPermPlugin))
+		}
+		var pl *Plugin
+		for _, p := range cv.Pgns {
+			if p.Name == ct.Name {
+				pl = p
+			}
+		}
+		if pl == nil {
+			return nicolive.MakeError(nicolive.ErrOther, "no plugin named "+ct.Name)
+		}
+		vs, err := pl.settingValues(cv.plugStrg)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmPlugSettingsGet, CtDirectngmPlugSettingsGet{pl.Name, pl.Settings, vs})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectPlugSend:
+		var ct CtDirectPlugSend
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		to, err := cv.findPlugin(ct.Name, ct.No)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		fail := to.WriteMess(NewMessageMust(DomainDirectngm, CommDirectngmPlugMessage, CtDirectngmPlugMessage{
+			From:    cv.PluginName(m.plgno),
+			FromNo:  m.plgno,
+			Command: ct.Command,
+			Content: ct.Content,
+		}))
+		if fail {
+			return nicolive.MakeError(nicolive.ErrOther, fmt.Sprintf("plugin [%s] is disabled or not connected", to.Name))
+		}
+		return nil
+	case CommDirectCommentBacklog:
+		var ct CtDirectCommentBacklog
+		if len(m.Content) != 0 {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		pl, err := cv.Plugin(m.plgno)
+		if err != nil {
+			return err
+		}
+		for _, bm := range cv.backlog.backlogMessages(ct.Limit) {
+			pl.WriteMess(bm)
+		}
+		return nil
+	case CommDirectStateGet:
+		t, err = NewMessage(DomainDirectngm, CommDirectngmStateGet, cv.state())
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+	case CommDirectTimerSet:
+		var ct CtDirectTimerSet
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		pl, err := cv.Plugin(m.plgno)
+		if err != nil {
+			return err
+		}
+		if ct.After < 0 || ct.Interval < 0 {
+			return nicolive.MakeError(nicolive.ErrOther, "negative time in the timer")
+		}
+		now := time.Now()
+		base := now
+		if ct.FromBroadOpen {
+			if cv.Lw == nil {
+				return nicolive.MakeError(nicolive.ErrOther, "not connected to live")
+			}
+			base = cv.Lw.Stream.OpenTime
+		}
+		interval := time.Duration(ct.Interval) * time.Second
+		first, err := firstFireTime(now, base, time.Duration(ct.After)*time.Second, interval)
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		if err := cv.timers.Set(pl, ct.Name, first, interval); err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
+		return nil
+	case CommDirectTimerCancel:
+		var ct CtDirectTimerCancel
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+		}
+		pl, err := cv.Plugin(m.plgno)
+		if err != nil {
+			return err
+		}
+		if !cv.timers.Cancel(pl, ct.Name) {
+			return nicolive.MakeError(nicolive.ErrOther, "no timer named "+ct.Name)
+		}
+		return nil
+	case CommDirectAPISchema:
+		var ct CtDirectAPISchema
+		if len(m.Content) != 0 {
+			if err := json.Unmarshal(m.Content, &ct); err != nil {
+				return nicolive.MakeError(nicolive.ErrOther, "JSON error in the content : "+err.Error())
+			}
+		}
+		t, err = NewMessage(DomainDirectngm, CommDirectngmAPISchema, CtDirectngmAPISchema{APISchemas(ct.Domain, ct.Command)})
+		if err != nil {
+			return nicolive.ErrFromStdErr(err)
+		}
 	default:
 		return nicolive.MakeError(nicolive.ErrOther, "Message : invalid query command : "+m.Command)
 	}
@@ -14,6 +14,39 @@ type SettingsSlot struct {
 	AutoFollowNextWaku bool            `yaml:"auto_follow_next_waku" json:"auto_follow_next_waku"`
 	OwnerComment       bool            `yaml:"owner_comment"         json:"owner_comment"`
 	PluginDisable      map[string]bool `yaml:"plugin_disable"        json:"plugin_disable"`
+
+	PluginPingInterval  int    `yaml:"plugin_ping_interval"   json:"plugin_ping_interval"`   // Seconds.  0 disables pings.
+	PluginPingMissLimit int    `yaml:"plugin_ping_miss_limit" json:"plugin_ping_miss_limit"` // Number of missed pings in a row to take the action
+	PluginPingAction    string `yaml:"plugin_ping_action"     json:"plugin_ping_action"`     // notify, disable or restart
+
+	CommentBacklogSize int `yaml:"comment_backlog_size" json:"comment_backlog_size"` // Number of recent comments kept for replaying
+
+	KotehanLearn bool   `yaml:"kotehan_learn" json:"kotehan_learn"` // Learn user names from "@name" in comments
+	KotehanScope string `yaml:"kotehan_scope" json:"kotehan_scope"` // global or community
+
+	History184 bool `yaml:"history184" json:"history184"` // Record the commenter history of 184 users too
+
+	NG        NGSettings        `yaml:"ng"        json:"ng"`
+	Thumbnail ThumbnailSettings `yaml:"thumbnail" json:"thumbnail"`
+}
+
+// NGSettings is settings of the NG (mute) filter.
+// NG comments are dropped before they reach plugins unless Mark is true.
+type NGSettings struct {
+	Words    []string `yaml:"words"     json:"words"`     // Comments which contain one of the words
+	Regexps  []string `yaml:"regexps"   json:"regexps"`   // Comments which match one of the regular expressions
+	Users    []string `yaml:"users"     json:"users"`     // Comments of the user IDs
+	Block184 bool     `yaml:"block184"  json:"block184"`  // Comments of anonymous (184) users
+	MinScore int      `yaml:"min_score" json:"min_score"` // Comments which have lower score than this.  0 disables it.
+	Mark     bool     `yaml:"mark"      json:"mark"`      // Send NG comments with the "ng" field instead of dropping them
+}
+
+// ThumbnailSettings is settings of the local cache of user thumbnails.
+type ThumbnailSettings struct {
+	Cache    bool   `yaml:"cache"     json:"cache"`     // Download thumbnails into the cache directory
+	MaxSize  int    `yaml:"max_size"  json:"max_size"`  // MB of the cache directory.  0 or less disables the limit.
+	MaxAge   int    `yaml:"max_age"   json:"max_age"`   // Days until cached thumbnails are downloaded again.  0 or less disables expiry.
+	HTTPPort string `yaml:"http_port" json:"http_port"` // Port to serve the cache on localhost.  Empty disables it.  Applied at start.
 }
 
 // NewSettingsSlot creates new SettingsSlot with default values.
@@ -25,6 +58,26 @@ func NewSettingsSlot() *SettingsSlot {
 		AutoFollowNextWaku: true,
 		OwnerComment:       true,
 		PluginDisable:      make(map[string]bool),
+
+		PluginPingInterval:  30,
+		PluginPingMissLimit: 3,
+		PluginPingAction:    PluginPingActionNotify,
+
+		CommentBacklogSize: 100,
+
+		KotehanLearn: true,
+		KotehanScope: KotehanScopeGlobal,
+
+		NG: NGSettings{
+			Words:   []string{},
+			Regexps: []string{},
+			Users:   []string{},
+		},
+		Thumbnail: ThumbnailSettings{
+			Cache:   true,
+			MaxSize: 50,
+			MaxAge:  7,
+		},
 	}
 }
 
@@ -49,6 +102,9 @@ func (s *SettingsSlot) Duplicate() SettingsSlot {
 	for k, c := range s.PluginDisable {
 		ns.PluginDisable[k] = c
 	}
+	ns.NG.Words = append([]string{}, s.NG.Words...)
+	ns.NG.Regexps = append([]string{}, s.NG.Regexps...)
+	ns.NG.Users = append([]string{}, s.NG.Users...)
 	return ns
 }
 
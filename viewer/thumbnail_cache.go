@@ -0,0 +1,281 @@
+package viewer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	thumbnailDirName         = "thumbnail"
+	thumbnailHTTPPrefix      = "/thumbnails/"
+	thumbnailMaxFileSize     = 1 << 20
+	thumbnailQueueSize       = 100
+	thumbnailDownloadTimeout = 30 * time.Second
+)
+
+// A thumbnailCache downloads thumbnails of users into a directory in the background.
+// Files are named by the hash of the URL, and removed when they are expired or the directory is too large.
+type thumbnailCache struct {
+	dir    string
+	client *http.Client
+	quit   <-chan struct{}
+	wg     *sync.WaitGroup       // The downloading goroutine is counted in it
+	done   func(id, path string) // Called for each waiting user after downloading
+	log    *log.Logger
+
+	mu      sync.Mutex
+	conf    ThumbnailSettings
+	pending map[string][]string // URL to IDs of users waiting for it
+	queue   chan string
+	running bool
+}
+
+func newThumbnailCache(dir string, conf ThumbnailSettings, quit <-chan struct{}, wg *sync.WaitGroup,
+	done func(id, path string), l *log.Logger) *thumbnailCache {
+	return &thumbnailCache{
+		dir:     dir,
+		client:  &http.Client{Timeout: thumbnailDownloadTimeout},
+		quit:    quit,
+		wg:      wg,
+		done:    done,
+		log:     l,
+		conf:    conf,
+		pending: make(map[string][]string),
+		queue:   make(chan string, thumbnailQueueSize),
+	}
+}
+
+// Set applies the settings.
+func (c *thumbnailCache) Set(conf ThumbnailSettings) {
+	c.mu.Lock()
+	c.conf = conf
+	c.mu.Unlock()
+}
+
+// expired returns whether the file modified at mt is expired.
+func (s *ThumbnailSettings) expired(mt, now time.Time) bool {
+	return s.MaxAge > 0 && now.Sub(mt) >= time.Duration(s.MaxAge)*24*time.Hour
+}
+
+// overSize returns whether size bytes is over the max size of the directory.
+func (s *ThumbnailSettings) overSize(size int64) bool {
+	return s.MaxSize > 0 && size > int64(s.MaxSize)<<20
+}
+
+// thumbnailFileName returns the name of the cached file of the URL.
+func thumbnailFileName(url string) string {
+	h := sha1.Sum([]byte(url))
+	ext := strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+	default:
+		ext = ".jpg"
+	}
+	return hex.EncodeToString(h[:]) + ext
+}
+
+// Path returns the path to the cached thumbnail of the URL.
+// If it is not cached or expired, it returns an empty string and queues downloading it,
+// and done is called with the ID of the user after that.
+func (c *thumbnailCache) Path(id, url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.conf.Cache {
+		return ""
+	}
+
+	p := filepath.Join(c.dir, thumbnailFileName(url))
+	if fi, err := os.Stat(p); err == nil && !c.conf.expired(fi.ModTime(), time.Now()) {
+		return p
+	}
+
+	if ids, ok := c.pending[url]; ok {
+		for _, i := range ids {
+			if i == id {
+				return ""
+			}
+		}
+		c.pending[url] = append(ids, id)
+		return ""
+	}
+	select {
+	case c.queue <- url:
+		c.pending[url] = []string{id}
+	default:
+		// It will be requested again by the next comment.
+		return ""
+	}
+	if !c.running {
+		select {
+		case <-c.quit:
+			return ""
+		default:
+		}
+		c.running = true
+		c.wg.Add(1)
+		go c.run()
+	}
+	return ""
+}
+
+func (c *thumbnailCache) run() {
+	defer c.wg.Done()
+	for {
+		var url string
+		select {
+		case url = <-c.queue:
+		case <-c.quit:
+			return
+		}
+
+		p, err := c.download(url)
+		if err == nil {
+			err = c.prune(time.Now())
+		}
+		c.mu.Lock()
+		ids := c.pending[url]
+		delete(c.pending, url)
+		c.mu.Unlock()
+		if err != nil {
+			c.log.Println(err)
+			continue
+		}
+		if c.done != nil {
+			for _, id := range ids {
+				c.done(id, p)
+			}
+		}
+	}
+}
+
+// download saves the thumbnail of the URL into the directory and returns the path.
+func (c *thumbnailCache) download(url string) (p string, err error) {
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return "", err
+	}
+	res, err := c.client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := res.Body.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s : %s", url, res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "image/") {
+		return "", fmt.Errorf("downloading %s : not an image : %s", url, ct)
+	}
+
+	f, err := ioutil.TempFile(c.dir, ".download")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(res.Body, thumbnailMaxFileSize+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > thumbnailMaxFileSize {
+		err = fmt.Errorf("downloading %s : too large", url)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	p = filepath.Join(c.dir, thumbnailFileName(url))
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return p, nil
+}
+
+// prune removes expired files and old files over the max size of the directory.
+func (c *thumbnailCache) prune(now time.Time) error {
+	c.mu.Lock()
+	conf := c.conf
+	c.mu.Unlock()
+
+	fis, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().After(fis[j].ModTime())
+	})
+	var size int64
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		size += fi.Size()
+		if !conf.expired(fi.ModTime(), now) && !conf.overSize(size) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves cached thumbnails on thumbnailHTTPPrefix + the file name.
+func (c *thumbnailCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, thumbnailHTTPPrefix)
+	if name == "" || name == r.URL.Path || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, filepath.Join(c.dir, name))
+}
+
+// emitThumbnailUpdate emits User.Update of the user whose thumbnail is downloaded.
+func (cv *CommentViewer) emitThumbnailUpdate(id, path string) {
+	select {
+	case <-cv.quit:
+		return
+	default:
+	}
+	u, err := cv.prcdnle.userDB.Fetch(id)
+	if err != nil {
+		cv.cli.log.Println(err)
+		return
+	}
+	cv.emit(NewMessageMust(DomainNagome, CommNagomeUserUpdate, CtNagomeUserUpdate{User: *u, ThumbnailPath: path}))
+}
+
+// thumbnailHTTPServer serves the thumbnail cache on localhost until quitting.
+func (cv *CommentViewer) thumbnailHTTPServer(port string) {
+	defer cv.wg.Done()
+
+	srv := &http.Server{Addr: "127.0.0.1:" + port, Handler: cv.thumbnails}
+	go func() {
+		<-cv.quit
+		if err := srv.Close(); err != nil {
+			cv.cli.log.Println(err)
+		}
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		cv.cli.log.Println(err)
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Thumbnail server", err.Error())
+	}
+}
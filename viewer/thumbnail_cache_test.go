@@ -0,0 +1,163 @@
+package viewer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThumbnailCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	img := []byte("\x89PNG thumbnail")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	})
+	mux.HandleFunc("/big.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, thumbnailMaxFileSize+1))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(quit)
+		wg.Wait()
+	}()
+	type done struct{ id, path string }
+	dch := make(chan done, 1)
+	cdir := filepath.Join(dir, thumbnailDirName)
+	c := newThumbnailCache(cdir, ThumbnailSettings{Cache: true, MaxSize: 1, MaxAge: 1}, quit, &wg,
+		func(id, path string) { dch <- done{id, path} }, log.New(ioutil.Discard, "", 0))
+
+	url := ts.URL + "/a.png"
+	if p := c.Path("1", url); p != "" {
+		t.Fatalf("Should not be cached yet but %s", p)
+	}
+	c.Path("1", url)
+	var d done
+	select {
+	case d = <-dch:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Not downloaded")
+	}
+	if d.id != "1" || d.path != filepath.Join(cdir, thumbnailFileName(url)) {
+		t.Fatalf("Unexpected download %#v", d)
+	}
+	select {
+	case d = <-dch:
+		t.Fatalf("Should be downloaded once %#v", d)
+	default:
+	}
+	if p := c.Path("2", url); p != d.path {
+		t.Fatalf("Should be %s but %s", d.path, p)
+	}
+	if b, err := ioutil.ReadFile(d.path); err != nil || !bytes.Equal(b, img) {
+		t.Fatalf("Unexpected file %q %v", b, err)
+	}
+	if p := c.Path("3", "url"); p != "" {
+		t.Fatalf("Should ignore a URL which is not http %s", p)
+	}
+
+	for _, u := range []string{"/big.png", "/page", "/notfound.png"} {
+		if _, err := c.download(ts.URL + u); err == nil {
+			t.Errorf("%s : should fail", u)
+		}
+	}
+
+	// Serving
+	for path, code := range map[string]int{
+		thumbnailHTTPPrefix + filepath.Base(d.path): http.StatusOK,
+		thumbnailHTTPPrefix:                         http.StatusNotFound,
+		thumbnailHTTPPrefix + "../setting.yml":      http.StatusNotFound,
+		"/" + filepath.Base(d.path):                 http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Errorf("%s : should be %d but %d", path, code, w.Code)
+		}
+	}
+
+	// Expiry and the size limit
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+		kept bool
+	}{
+		{"new.jpg", 600 << 10, time.Hour, true},
+		{"old.jpg", 600 << 10, 2 * time.Hour, false},
+		{"expired.jpg", 1, 48 * time.Hour, false},
+	}
+	for _, f := range files {
+		p := filepath.Join(cdir, f.name)
+		if err := ioutil.WriteFile(p, make([]byte, f.size), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.prune(now); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(cdir, f.name))
+		if kept := err == nil; kept != f.kept {
+			t.Errorf("%s : kept should be %v", f.name, f.kept)
+		}
+	}
+	if _, err := os.Stat(d.path); err != nil {
+		t.Fatal("The newest file should be kept")
+	}
+
+	// 0 disables the limits
+	c.Set(ThumbnailSettings{Cache: true})
+	p := filepath.Join(cdir, "expired.jpg")
+	if err := ioutil.WriteFile(p, make([]byte, 2<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, now.Add(-48*time.Hour), now.Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Fatal("Files should be kept without limits")
+	}
+	if c.Path("2", url) != d.path {
+		t.Fatal("Cached file should be used without expiry")
+	}
+
+	c.Set(ThumbnailSettings{})
+	if p := c.Path("1", url); p != "" {
+		t.Fatalf("Should be disabled but %s", p)
+	}
+}
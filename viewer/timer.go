@@ -0,0 +1,149 @@
+package viewer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	maxTimersPerPlugin = 64
+	minTimerInterval   = time.Second
+)
+
+type timerKey struct {
+	pl   *Plugin
+	name string
+}
+
+// A timerService runs timers registered by plugins and sends Timer.Fired to them.
+// Timers are cancelled when the plugin disconnects or the broadcast closes.
+type timerService struct {
+	cv     *CommentViewer
+	mu     sync.Mutex
+	timers map[timerKey]chan struct{}
+}
+
+func newTimerService(cv *CommentViewer) *timerService {
+	return &timerService{cv: cv, timers: make(map[timerKey]chan struct{})}
+}
+
+// Set registers a timer which fires at first and every interval after that.
+// A timer with the same name of the plugin is replaced.  Interval 0 means a one-shot timer.
+func (s *timerService) Set(pl *Plugin, name string, first time.Time, interval time.Duration) error {
+	if name == "" {
+		return fmt.Errorf("empty timer name")
+	}
+	if interval != 0 && interval < minTimerInterval {
+		return fmt.Errorf("interval of timer \"%s\" is shorter than %v", name, minTimerInterval)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := timerKey{pl, name}
+	if stop, ok := s.timers[k]; ok {
+		close(stop)
+		delete(s.timers, k)
+	} else {
+		n := 0
+		for tk := range s.timers {
+			if tk.pl == pl {
+				n++
+			}
+		}
+		if n >= maxTimersPerPlugin {
+			return fmt.Errorf("plugin [%s] has too many timers", pl.Name)
+		}
+	}
+	stop := make(chan struct{})
+	s.timers[k] = stop
+	go s.run(k, stop, first, interval)
+	return nil
+}
+
+func (s *timerService) run(k timerKey, stop chan struct{}, first time.Time, interval time.Duration) {
+	next := first
+	t := time.NewTimer(time.Until(next))
+	defer t.Stop()
+	for n := 1; ; n++ {
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+		// Sent through the dispatcher not to block on the plugin here.
+		m := NewMessageMust(DomainDirectngm, CommDirectngmTimerFired, CtDirectngmTimerFired{k.name, n, next})
+		m.to = k.pl
+		select {
+		case s.cv.Evch <- m:
+		case <-stop:
+			return
+		case <-s.cv.quit:
+			return
+		}
+		if interval == 0 {
+			s.remove(k, stop)
+			return
+		}
+		next = next.Add(interval)
+		t.Reset(time.Until(next))
+	}
+}
+
+func (s *timerService) remove(k timerKey, stop chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timers[k] == stop {
+		delete(s.timers, k)
+	}
+}
+
+// Cancel cancels the timer.  It returns false if the timer is not found.
+func (s *timerService) Cancel(pl *Plugin, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := timerKey{pl, name}
+	stop, ok := s.timers[k]
+	if ok {
+		close(stop)
+		delete(s.timers, k)
+	}
+	return ok
+}
+
+// CancelPlugin cancels all timers of the plugin.
+func (s *timerService) CancelPlugin(pl *Plugin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, stop := range s.timers {
+		if k.pl == pl {
+			close(stop)
+			delete(s.timers, k)
+		}
+	}
+}
+
+// CancelAll cancels all timers.
+func (s *timerService) CancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, stop := range s.timers {
+		close(stop)
+		delete(s.timers, k)
+	}
+}
+
+// firstFireTime returns the time when the timer fires first.
+// If base is in the past, it returns the first time after now for periodic timers.
+func firstFireTime(now, base time.Time, after, interval time.Duration) (time.Time, error) {
+	t := base.Add(after)
+	if !t.Before(now) {
+		return t, nil
+	}
+	if interval == 0 {
+		return time.Time{}, fmt.Errorf("the time to fire has already passed")
+	}
+	n := now.Sub(t)/interval + 1
+	return t.Add(n * interval), nil
+}
@@ -0,0 +1,119 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFirstFireTime(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		base     time.Time
+		after    time.Duration
+		interval time.Duration
+		want     time.Time
+		err      bool
+	}{
+		{now, time.Minute, 0, now.Add(time.Minute), false},
+		{now.Add(-time.Hour), 10 * time.Minute, 0, time.Time{}, true},
+		{now.Add(-time.Hour), 0, 25 * time.Minute, now.Add(15 * time.Minute), false},
+		{now.Add(-time.Hour), 0, 30 * time.Minute, now.Add(30 * time.Minute), false},
+		{now.Add(-time.Hour), 90 * time.Minute, 30 * time.Minute, now.Add(30 * time.Minute), false},
+	}
+	for i, test := range tests {
+		got, err := firstFireTime(now, test.base, test.after, test.interval)
+		if (err != nil) != test.err {
+			t.Errorf("%d : %v", i, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%d : Should be %v but %v", i, test.want, got)
+		}
+	}
+}
+
+func TestTimerService(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	cv.Settings.PluginPingInterval = 0
+	p := newPlugin(cv)
+	p.Name = "timer"
+	cv.AddPlugin(newPlugin(cv))
+	cv.AddPlugin(p)
+
+	pr, pw := io.Pipe()
+	if err := p.Open(&testRwc{pr, pw, pr, t}, true); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	s := cv.timers
+	now := time.Now()
+	if err := s.Set(p, "cancelled", now.Add(50*time.Millisecond), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(p, "once", now.Add(100*time.Millisecond), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(p, "short", now, time.Millisecond); err == nil {
+		t.Fatal("Too short interval should be an error")
+	}
+	if !s.Cancel(p, "cancelled") {
+		t.Fatal("Timer \"cancelled\" should be found")
+	}
+	if s.Cancel(p, "none") {
+		t.Fatal("Timer \"none\" should not be found")
+	}
+
+	// Timer.Fired is sent to the plugin through the dispatcher.
+	for {
+		var m *Message
+		select {
+		case m = <-cv.Evch:
+		case <-time.After(time.Second):
+			t.Fatal("Timer.Fired should be emitted")
+		}
+		if m.Command != CommDirectngmTimerFired {
+			continue
+		}
+		if m.to != p {
+			t.Fatalf("Timer.Fired should be sent to the plugin but %v", m.to)
+		}
+		var ct CtDirectngmTimerFired
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			t.Fatal(err)
+		}
+		if ct.Name != "once" || ct.Count != 1 {
+			t.Fatalf("Should be once 1 but %v", ct)
+		}
+		break
+	}
+	// Wait for removing the one-shot timer after emitting.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.Set(p, "periodic", now.Add(time.Hour), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s.CancelPlugin(p)
+	if s.Cancel(p, "periodic") {
+		t.Fatal("Timers should be cancelled with the plugin")
+	}
+	if s.Cancel(p, "once") {
+		t.Fatal("One-shot timer should be removed after firing")
+	}
+}
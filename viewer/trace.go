@@ -0,0 +1,284 @@
+package viewer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events in TraceRecord
+const (
+	TraceEventIn     = "in"     // A message entered the dispatcher
+	TraceEventFilter = "filter" // A message was sent to a filter plugin
+	TraceEventOut    = "out"    // A message was broadcast to subscribing plugins
+	TraceEventReject = "reject" // A message was rejected
+)
+
+// A TraceRecord is a line of a trace file.
+type TraceRecord struct {
+	Time    time.Time       `json:"time"`
+	Event   string          `json:"event"`
+	Domain  string          `json:"domain"`
+	Command string          `json:"command"`
+	Content json.RawMessage `json:"content,omitempty"`
+	From    string          `json:"from"`    // Name of the source plugin
+	FromNo  int             `json:"from_no"` // No of the source plugin.  -1 for Nagome.
+	To      []string        `json:"to,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// A tracer writes TraceRecords to a file in JSON Lines.
+// Methods of nil tracer do nothing, so tracing is disabled by default.
+type tracer struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newTracer(path string) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &tracer{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (t *tracer) record(r *TraceRecord) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_ = t.enc.Encode(r)
+}
+
+// Close closes the trace file.
+func (t *tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.f.Close()
+}
+
+// trace records the event of the message in the dispatcher if tracing is enabled.
+func (cv *CommentViewer) trace(ev string, m *Message, to []string, errDesc string) {
+	if cv.tracer == nil {
+		return
+	}
+	ct := m.Content
+	if strings.TrimSuffix(m.Domain, DomainSuffixFilter) == DomainQuery && m.Command == CommQueryAccountSet {
+		// It has the mail address and the password.
+		ct = json.RawMessage(`"(redacted)"`)
+	}
+	cv.tracer.record(&TraceRecord{
+		Time:    time.Now(),
+		Event:   ev,
+		Domain:  m.Domain,
+		Command: m.Command,
+		Content: ct,
+		From:    cv.PluginName(m.plgno),
+		FromNo:  m.plgno,
+		To:      to,
+		Error:   errDesc,
+	})
+}
+
+// handleTraceInject receives TraceRecords from the connection and puts them into the dispatcher.
+// Only "in" records are injected.  The source in the record is not trusted, so they are sent as
+// messages of Nagome and the ones which need permissions of plugins are not injected.
+func (cv *CommentViewer) handleTraceInject(c io.ReadWriteCloser, dec *json.Decoder) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-cv.quit:
+		case <-done:
+		}
+		err := c.Close()
+		if err != nil {
+			cv.cli.log.Println(err)
+		}
+	}()
+
+	n := 0
+	for {
+		var r TraceRecord
+		if err := dec.Decode(&r); err != nil {
+			if err != io.EOF {
+				cv.cli.log.Println(err)
+			}
+			break
+		}
+		if r.Event != TraceEventIn {
+			continue
+		}
+		if err := checkTraceInject(&r); err != nil {
+			cv.cli.log.Println("trace inject : " + err.Error())
+			continue
+		}
+		m := &Message{Domain: r.Domain, Command: r.Command, Content: r.Content, plgno: -1, origin: -1}
+		select {
+		case cv.Evch <- m:
+			n++
+		case <-cv.quit:
+			return
+		}
+	}
+	cv.cli.log.Printf("trace inject : injected %d messages\n", n)
+}
+
+// checkTraceInject returns an error if the record can't be injected.
+// Injected messages have no permission, since anyone connecting to the port can send them.
+func checkTraceInject(r *TraceRecord) error {
+	if r.Domain == DomainDirect {
+		return fmt.Errorf("%s %s : direct messages can't be injected", r.Domain, r.Command)
+	}
+	// Filter hops are recorded as "filter", and they would skip checks of the domain
+	if strings.HasSuffix(r.Domain, DomainSuffixFilter) {
+		return fmt.Errorf("%s %s : messages to filters can't be injected", r.Domain, r.Command)
+	}
+	if perm, ok := commandPermissions[strings.TrimSuffix(r.Domain, DomainSuffixFilter)][r.Command]; ok {
+		return fmt.Errorf("%s %s : messages which need the permission \"%s\" can't be injected", r.Domain, r.Command, perm)
+	}
+	if verrs := ValidateContent(r.Domain, r.Command, r.Content); len(verrs) != 0 {
+		return fmt.Errorf("%s %s : %s", r.Domain, r.Command, verrs[0].Error())
+	}
+	return nil
+}
+
+// A traceFilter selects TraceRecords.  Empty fields match all.
+type traceFilter struct {
+	Domain  string
+	Command string
+	Plugin  string // Source or destination
+	Event   string
+}
+
+// parseTraceFilter parses comma separated key=value pairs like "domain=nagome_comment,plugin=tts".
+func parseTraceFilter(s string) (traceFilter, error) {
+	var f traceFilter
+	if s == "" {
+		return f, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return f, fmt.Errorf("invalid trace filter \"%s\" (use key=value)", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		switch k {
+		case "domain":
+			f.Domain = v
+		case "command":
+			f.Command = v
+		case "plugin":
+			f.Plugin = v
+		case "event":
+			f.Event = v
+		default:
+			return f, fmt.Errorf("unknown key \"%s\" in trace filter (domain, command, plugin, event)", k)
+		}
+	}
+	return f, nil
+}
+
+func (f *traceFilter) match(r *TraceRecord) bool {
+	if f.Domain != "" && f.Domain != r.Domain && f.Domain != strings.TrimSuffix(r.Domain, DomainSuffixFilter) {
+		return false
+	}
+	if f.Command != "" && f.Command != r.Command {
+		return false
+	}
+	if f.Event != "" && f.Event != r.Event {
+		return false
+	}
+	if f.Plugin != "" && f.Plugin != r.From {
+		found := false
+		for _, t := range r.To {
+			if t == f.Plugin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// readTrace calls fn for each record matching the filter.
+func readTrace(r io.Reader, f traceFilter, fn func(*TraceRecord) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16*1024*1024)
+	for l := 1; sc.Scan(); l++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var tr TraceRecord
+		if err := json.Unmarshal(sc.Bytes(), &tr); err != nil {
+			return fmt.Errorf("line %d : %s", l, err)
+		}
+		if !f.match(&tr) {
+			continue
+		}
+		if err := fn(&tr); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// printTrace writes the records in the trace in a readable format.
+func printTrace(r io.Reader, w io.Writer, f traceFilter) error {
+	return readTrace(r, f, func(tr *TraceRecord) error {
+		s := fmt.Sprintf("%s %-6s [%s] %s %s", tr.Time.Format("15:04:05.000"), tr.Event, tr.From, tr.Domain, tr.Command)
+		if len(tr.To) != 0 {
+			s += " -> " + strings.Join(tr.To, ", ")
+		}
+		if tr.Error != "" {
+			s += " : " + tr.Error
+		}
+		if len(tr.Content) != 0 {
+			s += "\n\t" + string(tr.Content)
+		}
+		_, err := fmt.Fprintln(w, s)
+		return err
+	})
+}
+
+// injectTrace sends "in" records in the trace to the running Nagome at addr.
+// The Nagome has to be run with tracing enabled.
+func injectTrace(r io.Reader, addr string, f traceFilter) (n int, err error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		cerr := c.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	enc := json.NewEncoder(c)
+	if err := enc.Encode(NewMessageMust(DomainDirect, CommDirectTraceInject, nil)); err != nil {
+		return 0, err
+	}
+	err = readTrace(r, f, func(tr *TraceRecord) error {
+		if tr.Event != TraceEventIn {
+			return nil
+		}
+		n++
+		return enc.Encode(tr)
+	})
+	return n, err
+}
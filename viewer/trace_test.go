@@ -0,0 +1,147 @@
+package viewer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceFilter(t *testing.T) {
+	if _, err := parseTraceFilter("domain"); err == nil {
+		t.Fatal("Filter without value should be an error")
+	}
+	if _, err := parseTraceFilter("dom=a"); err == nil {
+		t.Fatal("Unknown key should be an error")
+	}
+
+	r := &TraceRecord{Event: TraceEventFilter, Domain: "nagome_comment@filter", Command: "Got", From: "a", To: []string{"b"}}
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"", true},
+		{"domain=nagome_comment", true},
+		{"domain=nagome_comment@filter,command=Got", true},
+		{"domain=nagome", false},
+		{"plugin=b", true},
+		{"plugin=a, event=filter", true},
+		{"plugin=c", false},
+		{"event=in", false},
+	}
+	for _, test := range tests {
+		f, err := parseTraceFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.match(r) != test.match {
+			t.Errorf("%q : Should be %v", test.filter, test.match)
+		}
+	}
+}
+
+func TestTraceRecordAndInject(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	p := newPlugin(cv)
+	p.Name = "main"
+	cv.AddPlugin(p)
+
+	tpath := filepath.Join(savepath, "trace.jsonl")
+	if err := cv.EnableTrace(tpath); err != nil {
+		t.Fatal(err)
+	}
+	cv.trace(TraceEventIn, NewMessageMust(DomainQuery, CommQueryLogPrint, CtQueryLogPrint{"traced"}), nil, "")
+	m := NewMessageMust(DomainQuery, CommQueryLogPrint, CtQueryLogPrint{"from main"})
+	m.plgno = 0
+	cv.trace(TraceEventIn, m, nil, "")
+	cv.trace(TraceEventOut, m, []string{"a", "b"}, "")
+	m = NewMessageMust(DomainQuery, CommQueryAccountSet, CtQueryAccountSet{Mail: "a@example.com", Pass: "secret"})
+	m.plgno = 0
+	cv.trace(TraceEventIn, m, nil, "")
+	m = NewMessageMust(DomainQuery+DomainSuffixFilter, CommQueryAccountSet, CtQueryAccountSet{Mail: "a@example.com"})
+	m.plgno = 0
+	cv.trace(TraceEventIn, m, nil, "")
+	if err := cv.tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile(tpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(d, []byte("secret")) || bytes.Contains(d, []byte("a@example.com")) {
+		t.Fatalf("Account should be redacted :\n%s", d)
+	}
+	b := new(bytes.Buffer)
+	if err := printTrace(bytes.NewReader(d), b, traceFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(b.String(), CommQueryLogPrint) != 3 || !strings.Contains(b.String(), "-> a, b") {
+		t.Fatalf("Unexpected output :\n%s", b)
+	}
+
+	// inject
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		cv.wg.Add(1)
+		handleTCPPlugin(c, cv)
+	}()
+
+	n, err := injectTrace(bytes.NewReader(d), l.Addr().String(), traceFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("Should send 4 messages but %d", n)
+	}
+	// The source in the trace is not trusted and Account.Set needs a permission even through filters.
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-cv.Evch:
+			if m.Command != CommQueryLogPrint || m.plgno != -1 {
+				t.Fatalf("Unexpected message %v from %d", m, m.plgno)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("Messages were not injected")
+		}
+	}
+	select {
+	case m := <-cv.Evch:
+		t.Fatalf("Unexpected message %v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+	for _, r := range []*TraceRecord{
+		{Domain: DomainQuery + DomainSuffixFilter, Command: CommQueryAccountSet, Content: []byte(`{}`)},
+		{Domain: DomainQuery + DomainSuffixFilter, Command: CommQueryLogPrint, Content: []byte(`{"text":"a"}`)},
+	} {
+		if err := checkTraceInject(r); err == nil {
+			t.Errorf("%s %s should not be injected", r.Domain, r.Command)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package viewer
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+const userSearchDefaultLimit = 100
+
+var userColorRegex = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// isValidUserColor returns whether c is a color in "#rrggbb" or empty to clear it.
+func isValidUserColor(c string) bool {
+	return c == "" || userColorRegex.MatchString(c)
+}
+
+// normalizeTags trims tags and removes empty and duplicated ones.
+func normalizeTags(tags []string) []string {
+	ts := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			ts = appendUniq(ts, t)
+		}
+	}
+	return ts
+}
+
+// updateUser applies f to the user in the DB, stores it and emits User.Update.
+// A new user is made if the user is not in the DB.
+func (cv *CommentViewer) updateUser(id, title string, f func(u *nicolive.User)) error {
+	if id == "" {
+		return nicolive.MakeError(nicolive.ErrOther, "format error : ID is empty")
+	}
+	user, err := cv.prcdnle.userDB.Update(id, f)
+	if err != nil {
+		cv.EmitEvNewNotification(CtUINotificationTypeWarn, title, "DB error : "+err.Error())
+		return err
+	}
+
+	cv.emit(NewMessageMust(DomainNagome, CommNagomeUserUpdate, cv.newCtNagomeUserUpdate(user)))
+	return nil
+}
+
+// newCtNagomeUserUpdate makes the content of User.Update with the path to the cached thumbnail.
+func (cv *CommentViewer) newCtNagomeUserUpdate(u *nicolive.User) CtNagomeUserUpdate {
+	return CtNagomeUserUpdate{
+		User:          *u,
+		ThumbnailPath: cv.thumbnails.Path(u.ID, u.ThumbnailURL),
+	}
+}
+
+func hasUserName(u *nicolive.User) bool {
+	return u.Name != "" || len(u.CommunityNames) != 0
+}
+
+// userLastSeen returns the time of the last comment of the user in the community.
+// If the community is empty, it returns the latest one in all communities.
+func userLastSeen(u *nicolive.User, community string) time.Time {
+	if community != "" {
+		if c, ok := u.Communities[community]; ok {
+			return c.LastSeen
+		}
+		return time.Time{}
+	}
+	var ls time.Time
+	for _, c := range u.Communities {
+		if c.LastSeen.After(ls) {
+			ls = c.LastSeen
+		}
+	}
+	return ls
+}
+
+// match returns whether the user matches the condition.
+func (ct *CtDirectUserSearch) match(u *nicolive.User) bool {
+	if ct.Tag != "" && !u.HasTag(ct.Tag) {
+		return false
+	}
+	if ct.Hide != nil && u.Hide != *ct.Hide {
+		return false
+	}
+	if ct.Is184 != nil && u.Is184 != *ct.Is184 {
+		return false
+	}
+	if ct.HasName != nil && hasUserName(u) != *ct.HasName {
+		return false
+	}
+	if ct.SeenAfter != nil || ct.SeenBefore != nil {
+		ls := userLastSeen(u, ct.Community)
+		if ls.IsZero() ||
+			(ct.SeenAfter != nil && ls.Before(*ct.SeenAfter)) ||
+			(ct.SeenBefore != nil && !ls.Before(*ct.SeenBefore)) {
+			return false
+		}
+	}
+	if ct.Query == "" {
+		return true
+	}
+	q := strings.ToLower(ct.Query)
+	if strings.Contains(strings.ToLower(u.ID), q) ||
+		strings.Contains(strings.ToLower(u.Name), q) ||
+		strings.Contains(strings.ToLower(u.Note), q) {
+		return true
+	}
+	for _, n := range u.CommunityNames {
+		if strings.Contains(strings.ToLower(n), q) {
+			return true
+		}
+	}
+	return false
+}
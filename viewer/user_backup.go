@@ -0,0 +1,264 @@
+package viewer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+// Formats of exported users.
+const (
+	UserFormatJSON     = "json"     // Array of users.  It keeps all information.
+	UserFormatCSV      = "csv"      // CSV with a header line.  Only columns in the header are imported.
+	UserFormatNameList = "namelist" // Lines of ID and name separated by a tab or a comma like name lists of other comment viewers.  Import only.
+)
+
+const (
+	backupDirName      = "backup"
+	userExportPageSize = 1000
+)
+
+var userCSVHeader = []string{"id", "name", "is184", "create_time", "thumbnail_url", "note", "tags", "color", "hide"}
+
+// userFormatFromPath returns the format of the file decided by the extension.
+func userFormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return UserFormatJSON
+	case ".csv":
+		return UserFormatCSV
+	default:
+		return UserFormatNameList
+	}
+}
+
+// exportUsers writes all users in the DB to w in the format.
+// It returns the number of exported users.
+func exportUsers(db *nicolive.UserDB, w io.Writer, format string) (int, error) {
+	var write func(u *nicolive.User) error
+	var finish func() error
+	n := 0
+
+	switch format {
+	case UserFormatJSON:
+		bw := bufio.NewWriter(w)
+		if _, err := bw.WriteString("["); err != nil {
+			return 0, err
+		}
+		write = func(u *nicolive.User) error {
+			if n != 0 {
+				if _, err := bw.WriteString(","); err != nil {
+					return err
+				}
+			}
+			b, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(bw, "\n%s", b)
+			return err
+		}
+		finish = func() error {
+			if _, err := bw.WriteString("\n]\n"); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	case UserFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(userCSVHeader); err != nil {
+			return 0, err
+		}
+		write = func(u *nicolive.User) error {
+			return cw.Write([]string{
+				u.ID, u.Name, strconv.FormatBool(u.Is184), u.CreateTime.Format(time.RFC3339),
+				u.ThumbnailURL, u.Note, strings.Join(u.Tags, ";"), u.Color, strconv.FormatBool(u.Hide),
+			})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unsupported format for export : %s", format)
+	}
+
+	cursor := ""
+	for {
+		us, next, err := db.List(cursor, userExportPageSize, nil)
+		if err != nil {
+			return n, err
+		}
+		for _, u := range us {
+			if err := write(u); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return n, finish()
+}
+
+// importUsers reads users from r in the format and stores them into the DB.
+// Users in JSON replace the ones in the DB.
+// Users in other formats are merged into the ones in the DB, and empty values don't overwrite.
+// It returns the number of imported users.
+func importUsers(db *nicolive.UserDB, r io.Reader, format string) (int, error) {
+	switch format {
+	case UserFormatJSON:
+		var us []*nicolive.User
+		if err := json.NewDecoder(r).Decode(&us); err != nil {
+			return 0, err
+		}
+		for i, u := range us {
+			if u == nil || u.ID == "" {
+				return i, fmt.Errorf("user without ID at %d", i)
+			}
+			if err := db.Store(u); err != nil {
+				return i, err
+			}
+		}
+		return len(us), nil
+
+	case UserFormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return 0, err
+		}
+		col := make(map[string]int)
+		for i, h := range header {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		if _, ok := col["id"]; !ok {
+			return 0, fmt.Errorf("no id column in the CSV header")
+		}
+		n := 0
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return n, nil
+			}
+			if err != nil {
+				return n, err
+			}
+			v := make(map[string]string)
+			for c, i := range col {
+				if i < len(rec) {
+					v[c] = rec[i]
+				}
+			}
+			if err := mergeImportedUser(db, v); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+	case UserFormatNameList:
+		sc := bufio.NewScanner(r)
+		n := 0
+		for sc.Scan() {
+			l := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			sep := strings.IndexAny(l, "\t,")
+			if sep < 0 {
+				return n, fmt.Errorf("no name in the line : %s", l)
+			}
+			v := map[string]string{
+				"id":   strings.TrimSpace(l[:sep]),
+				"name": strings.TrimSpace(l[sep+1:]),
+			}
+			if err := mergeImportedUser(db, v); err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, sc.Err()
+
+	default:
+		return 0, fmt.Errorf("unsupported format for import : %s", format)
+	}
+}
+
+// mergeImportedUser merges values of columns in userCSVHeader into the user in the DB.
+func mergeImportedUser(db *nicolive.UserDB, v map[string]string) error {
+	id := v["id"]
+	if id == "" {
+		return fmt.Errorf("user without ID")
+	}
+	_, err := db.Update(id, func(u *nicolive.User) {
+		mergeUserValues(u, v)
+	})
+	return err
+}
+
+func mergeUserValues(u *nicolive.User, v map[string]string) {
+	if s := v["name"]; s != "" {
+		u.Name = s
+	}
+	if s := v["create_time"]; s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			u.CreateTime = t
+		}
+	}
+	if s := v["thumbnail_url"]; s != "" {
+		u.ThumbnailURL = s
+	}
+	if s := v["note"]; s != "" {
+		u.Note = s
+	}
+	if s := v["tags"]; s != "" {
+		u.Tags = normalizeTags(strings.Split(s, ";"))
+	}
+	if s := v["color"]; s != "" && isValidUserColor(s) {
+		u.Color = s
+	}
+	if b, err := strconv.ParseBool(v["hide"]); err == nil {
+		u.Hide = b
+	}
+}
+
+// backupUsers exports the DB to a file named with the current time in the backup directory in the save path.
+// It returns the path to the file.
+func backupUsers(db *nicolive.UserDB, savePath, format string, now time.Time) (path string, err error) {
+	if format != UserFormatJSON && format != UserFormatCSV {
+		return "", fmt.Errorf("unsupported format for backup : %s", format)
+	}
+	dir := filepath.Join(savePath, backupDirName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	path = filepath.Join(dir, "userdb-"+now.Format("20060102-150405")+"."+format)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = exportUsers(db, f, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Don't leave a broken backup
+		if rerr := os.Remove(path); rerr != nil {
+			return "", fmt.Errorf("%v (removing the file : %v)", err, rerr)
+		}
+		return "", err
+	}
+	return path, nil
+}
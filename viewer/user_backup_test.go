@@ -0,0 +1,145 @@
+package viewer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func openTestUserDB(t *testing.T) *nicolive.UserDB {
+	db, err := nicolive.NewUserDBWithStorage(nicolive.NewMemoryUserStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUserExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	src := openTestUserDB(t)
+	defer src.Close()
+	tm := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	us := []*nicolive.User{
+		{ID: "1", Name: "alice", CreateTime: tm, Note: "a, \"note\"", Tags: []string{"regular", "mod"}, Color: "#ff0000"},
+		{ID: "abc", Name: "bob", CreateTime: tm, Is184: true, Hide: true,
+			Communities: map[string]*nicolive.UserCommunity{"co1": {Comments: 3}}},
+	}
+	for _, u := range us {
+		if err := src.Store(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{UserFormatJSON, UserFormatCSV} {
+		b := new(bytes.Buffer)
+		n, err := exportUsers(src, b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(us) {
+			t.Fatalf("%s : exported %d users", format, n)
+		}
+
+		dst := openTestUserDB(t)
+		n, err = importUsers(dst, b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(us) {
+			t.Fatalf("%s : imported %d users", format, n)
+		}
+		for _, u := range us {
+			iu, err := dst.Fetch(u.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == UserFormatCSV {
+				// CSV doesn't have histories in communities
+				iu.Communities = u.Communities
+			}
+			if !iu.Equal(u) {
+				t.Errorf("%s : expected %#v but %#v", format, u, iu)
+			}
+		}
+		dst.Close()
+	}
+
+	// Merging a name list
+	nl := "\ufeff# comment\n1\tAlice\nabc,ボブ\n\n2,carol\n"
+	n, err := importUsers(src, strings.NewReader(nl), UserFormatNameList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("imported %d users", n)
+	}
+	u, err := src.Fetch("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Alice" || u.Note != us[0].Note {
+		t.Fatalf("Not merged %#v", u)
+	}
+	if u, err := src.Fetch("abc"); err != nil || u.Name != "ボブ" {
+		t.Fatalf("Not merged %#v %v", u, err)
+	}
+	if _, err := importUsers(src, strings.NewReader("1\n"), UserFormatNameList); err == nil {
+		t.Fatal("Should fail for a line without name")
+	}
+
+	path, err := backupUsers(src, dir, UserFormatJSON, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, backupDirName, "userdb-20170101-000000.json") {
+		t.Fatalf("Unexpected backup path %s", path)
+	}
+	if _, err := backupUsers(src, dir, UserFormatNameList, tm); err == nil {
+		t.Fatal("Should fail for namelist")
+	}
+
+	if _, err := importUsers(src, strings.NewReader("[null]"), UserFormatJSON); err == nil {
+		t.Fatal("Should fail for null user")
+	}
+
+	// A failed backup is removed
+	closed, err := nicolive.NewUserDB(filepath.Join(dir, "closed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backupUsers(closed, dir, UserFormatJSON, tm.Add(time.Second)); err == nil {
+		t.Fatal("Should fail for closed DB")
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupDirName, "userdb-20170101-000001.json")); !os.IsNotExist(err) {
+		t.Fatalf("Failed backup is left : %v", err)
+	}
+
+	// The file is not created for an unsupported format
+	c := makeTestCLI(dir)
+	ep := filepath.Join(dir, "users.txt")
+	if err := c.runUserDBTool(ep, "", ""); err == nil {
+		t.Fatal("Should fail for namelist")
+	}
+	if _, err := os.Stat(ep); !os.IsNotExist(err) {
+		t.Fatalf("Export file is created : %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package viewer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+const (
+	userNameAPITimesAMinute = 6
+	userFetchMaxQueue       = 1000
+	userFetchMaxRetries     = 3
+	userFetchRetryDelay     = 30 * time.Second // Doubled in each retry
+	userFetchActiveBonus    = 10 * time.Second // Priority added by each comment while waiting
+	userFetchForceBonus     = time.Hour        // Priority added to explicit requests
+)
+
+type userFetchItem struct {
+	id        string
+	force     bool      // Requested explicitly.  The name in the DB is overwritten.
+	priority  time.Time // Later is fetched first
+	retries   int
+	notBefore time.Time
+}
+
+// A userFetcher fetches user information from the web in the background.
+// Requests for the same user are merged, and recent and active commenters are fetched first
+// within the rate limit of the API.
+type userFetcher struct {
+	cv         *CommentViewer
+	fetch      func(id string) (*nicolive.User, error)
+	limit      int           // Number of API calls in window
+	window     time.Duration // Interval of the rate limit
+	retryDelay time.Duration
+
+	mu      sync.Mutex
+	items   map[string]*userFetchItem
+	calls   []time.Time // Times of API calls in the current window
+	running bool
+	wake    chan struct{}
+}
+
+func newUserFetcher(cv *CommentViewer) *userFetcher {
+	f := &userFetcher{
+		cv:         cv,
+		limit:      userNameAPITimesAMinute,
+		window:     time.Minute,
+		retryDelay: userFetchRetryDelay,
+		items:      make(map[string]*userFetchItem),
+		wake:       make(chan struct{}, 1),
+	}
+	f.fetch = func(id string) (*nicolive.User, error) {
+		if cv.Ac == nil {
+			return nil, nicolive.MakeError(nicolive.ErrNotLogin, "no account")
+		}
+		return nicolive.FetchUserInfo(id, cv.Ac)
+	}
+	return f
+}
+
+// Request adds the user to the queue.
+// force is for explicit requests, which are fetched first and overwrite the name in the DB.
+// It returns false if the user can't be fetched (i.e. 184 users).
+func (f *userFetcher) Request(id string, force bool) bool {
+	if !f.request(id, force, time.Now()) {
+		return false
+	}
+	f.mu.Lock()
+	if !f.running {
+		select {
+		case <-f.cv.quit:
+			f.mu.Unlock()
+			return true
+		default:
+		}
+		f.running = true
+		f.cv.wg.Add(1)
+		go f.run()
+	}
+	f.mu.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (f *userFetcher) request(id string, force bool, now time.Time) bool {
+	if id == "" || nicolive.Is184UserID(id) {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	it, ok := f.items[id]
+	if ok {
+		if now.After(it.priority) {
+			it.priority = now
+		}
+		it.priority = it.priority.Add(userFetchActiveBonus)
+	} else {
+		if len(f.items) >= userFetchMaxQueue {
+			f.dropLowest()
+		}
+		it = &userFetchItem{id: id, priority: now}
+		f.items[id] = it
+	}
+	if force && !it.force {
+		it.force = true
+		it.priority = it.priority.Add(userFetchForceBonus)
+		it.notBefore = time.Time{}
+	}
+	return true
+}
+
+func (f *userFetcher) dropLowest() {
+	var low *userFetchItem
+	for _, it := range f.items {
+		if low == nil || it.priority.Before(low.priority) {
+			low = it
+		}
+	}
+	if low != nil {
+		delete(f.items, low.id)
+	}
+}
+
+// next removes the item to be fetched now from the queue and records the API call.
+// If there is nothing to fetch now, it returns the duration to wait, which is 0 for no items.
+func (f *userFetcher) next(now time.Time) (*userFetchItem, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.calls) > 0 && !now.Before(f.calls[0].Add(f.window)) {
+		f.calls = f.calls[1:]
+	}
+	if len(f.items) == 0 {
+		return nil, 0
+	}
+	if len(f.calls) >= f.limit {
+		return nil, f.calls[0].Add(f.window).Sub(now)
+	}
+
+	var top *userFetchItem
+	var wait time.Duration
+	for _, it := range f.items {
+		if now.Before(it.notBefore) {
+			if d := it.notBefore.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if top == nil || it.priority.After(top.priority) ||
+			(it.priority.Equal(top.priority) && it.id < top.id) {
+			top = it
+		}
+	}
+	if top == nil {
+		return nil, wait
+	}
+	delete(f.items, top.id)
+	f.calls = append(f.calls, now)
+	return top, 0
+}
+
+// retry puts the item back to the queue after the delay.
+// It is merged if the user is requested again while fetching.
+func (f *userFetcher) retry(it *userFetchItem, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	it.retries++
+	it.notBefore = now.Add(f.retryDelay << uint(it.retries-1))
+	if cur, ok := f.items[it.id]; ok {
+		cur.force = cur.force || it.force
+		cur.retries = it.retries
+		if cur.notBefore.Before(it.notBefore) {
+			cur.notBefore = it.notBefore
+		}
+		return
+	}
+	f.items[it.id] = it
+}
+
+func (f *userFetcher) run() {
+	defer f.cv.wg.Done()
+	for {
+		it, wait := f.next(time.Now())
+		if it != nil {
+			f.do(it)
+			continue
+		}
+
+		var t *time.Timer
+		var tc <-chan time.Time
+		if wait > 0 {
+			t = time.NewTimer(wait)
+			tc = t.C
+		}
+		select {
+		case <-f.wake:
+		case <-tc:
+		case <-f.cv.quit:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		select {
+		case <-f.cv.quit:
+			return
+		default:
+		}
+	}
+}
+
+func (f *userFetcher) do(it *userFetchItem) {
+	u, err := f.fetch(it.id)
+	if err != nil {
+		if nerr, ok := err.(nicolive.Error); ok && nerr.Type() == nicolive.ErrNetwork && it.retries < userFetchMaxRetries {
+			f.retry(it, time.Now())
+			return
+		}
+		f.cv.cli.log.Printf("fetching user %s failed : %v\n", it.id, err)
+		if it.force {
+			f.cv.EmitEvNewNotification(CtUINotificationTypeWarn, "Fetching the user info failed", err.Error())
+		}
+		return
+	}
+
+	select {
+	case <-f.cv.quit:
+		return
+	default:
+	}
+	err = f.cv.updateUser(it.id, "Fetching the user info failed", func(cu *nicolive.User) {
+		if it.force || cu.Name == "" {
+			cu.Name = u.Name
+		}
+		if u.ThumbnailURL != "" {
+			cu.ThumbnailURL = u.ThumbnailURL
+		}
+	})
+	if err != nil {
+		f.cv.cli.log.Println(err)
+	}
+}
@@ -0,0 +1,130 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestUserFetcherQueue(t *testing.T) {
+	f := newUserFetcher(nil)
+	f.limit = 3
+	t0 := time.Now()
+
+	for i, id := range []string{"1", "2", "3", "1"} {
+		if !f.request(id, false, t0.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("%s : should be requested", id)
+		}
+	}
+	if f.request("abc", false, t0) {
+		t.Fatal("184 user should not be requested")
+	}
+	f.request("4", true, t0.Add(4*time.Second))
+	if len(f.items) != 4 {
+		t.Fatalf("Requests should be merged %v", f.items)
+	}
+
+	now := t0.Add(5 * time.Second)
+	for _, exp := range []string{"4", "1", "3"} {
+		it, _ := f.next(now)
+		if it == nil || it.id != exp {
+			t.Fatalf("Should be %s but %v", exp, it)
+		}
+		if exp == "4" && !it.force {
+			t.Fatal("Explicit request should be forced")
+		}
+	}
+	it, wait := f.next(now)
+	if it != nil || wait != time.Minute {
+		t.Fatalf("Should wait for the rate limit but %v %v", it, wait)
+	}
+
+	now = now.Add(time.Minute)
+	it, _ = f.next(now)
+	if it == nil || it.id != "2" {
+		t.Fatalf("Should be 2 but %v", it)
+	}
+	f.retry(it, now)
+	it, wait = f.next(now)
+	if it != nil || wait != userFetchRetryDelay {
+		t.Fatalf("Should wait for retry but %v %v", it, wait)
+	}
+	it, _ = f.next(now.Add(userFetchRetryDelay))
+	if it == nil || it.id != "2" || it.retries != 1 {
+		t.Fatalf("Should retry 2 but %v", it)
+	}
+	if it, wait = f.next(now); it != nil || wait != 0 {
+		t.Fatalf("Queue should be empty but %v %v", it, wait)
+	}
+}
+
+func TestUserFetcherRun(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		cv.Cmm = nil // fake connection set below
+		cv.Quit()
+		cv.Wait()
+	}()
+
+	if err := cv.prcdnle.userDB.Store(&nicolive.User{ID: "1", Note: "note"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	f := cv.prcdnle.userFetch
+	f.retryDelay = time.Millisecond
+	f.fetch = func(id string) (*nicolive.User, error) {
+		calls++
+		if calls == 1 {
+			return nil, nicolive.MakeError(nicolive.ErrNetwork, "timeout")
+		}
+		return &nicolive.User{ID: id, Name: "alice", ThumbnailURL: "url"}, nil
+	}
+
+	cv.Settings.UserNameGet = true
+	cv.Cmm = &nicolive.CommentConnection{}
+	cv.prcdnle.ProceedNicoEvent(&nicolive.Event{
+		Type:    nicolive.EventTypeCommentGot,
+		Content: nicolive.Comment{UserID: "1", Comment: "hello", Date: time.Now()},
+	})
+	if m := <-cv.Evch; m.Command != CommCommentGot {
+		t.Fatalf("Should be %s but %v", CommCommentGot, m)
+	}
+
+	select {
+	case m := <-cv.Evch:
+		var u CtNagomeUserUpdate
+		if err := json.Unmarshal(m.Content, &u); err != nil {
+			t.Fatal(err)
+		}
+		if m.Command != CommNagomeUserUpdate || u.Name != "alice" || u.ThumbnailURL != "url" || u.Note != "note" {
+			t.Fatalf("Unexpected update %v %#v", m, u)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("User.Update is not emitted")
+	}
+	if calls != 2 {
+		t.Fatalf("Should retry once but called %d times", calls)
+	}
+
+	m := NewMessageMust(DomainQuery, CommQueryUserFetch, CtQueryUserFetch{ID: "abc"})
+	if err := processNagomeMessage(cv, m); err == nil {
+		t.Fatal("Should fail for 184 user")
+	}
+}
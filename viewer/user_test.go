@@ -0,0 +1,131 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+)
+
+func TestUserAttributes(t *testing.T) {
+	savepath, err := ioutil.TempDir("", DefaultAppName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := os.RemoveAll(savepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	cli := makeTestCLI(savepath)
+
+	cv := NewCommentViewer("0", cli)
+	defer func() {
+		err := cv.plugStrg.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, m := range []*Message{
+		NewMessageMust(DomainQuery, CommQueryUserSetNote, CtQueryUserSetNote{ID: "1", Note: "Likes cats"}),
+		NewMessageMust(DomainQuery, CommQueryUserSetTags, CtQueryUserSetTags{ID: "1", Tags: []string{" regular", "mod", "", "regular"}}),
+		NewMessageMust(DomainQuery, CommQueryUserSetColor, CtQueryUserSetColor{ID: "1", Color: "#FF0000"}),
+		NewMessageMust(DomainQuery, CommQueryUserSetHide, CtQueryUserSetHide{ID: "abc", Hide: true}),
+	} {
+		if err := processNagomeMessage(cv, m); err != nil {
+			t.Fatal(err)
+		}
+		if u := <-cv.Evch; u.Command != CommNagomeUserUpdate {
+			t.Fatalf("Should emit User.Update but %v", u)
+		}
+	}
+	m := NewMessageMust(DomainQuery, CommQueryUserSetColor, CtQueryUserSetColor{ID: "1", Color: "red"})
+	if err := processNagomeMessage(cv, m); err == nil {
+		t.Fatal("Should fail for invalid color")
+	}
+
+	cv.prcdnle.ProceedNicoEvent(&nicolive.Event{
+		Type:    nicolive.EventTypeCommentGot,
+		Content: nicolive.Comment{UserID: "1", Comment: "hello"},
+	})
+	var ct CtCommentGot
+	if err := json.Unmarshal((<-cv.Evch).Content, &ct); err != nil {
+		t.Fatal(err)
+	}
+	if ct.UserNote != "Likes cats" || !reflect.DeepEqual(ct.UserTags, []string{"regular", "mod"}) ||
+		ct.UserColor != "#FF0000" || ct.UserHide {
+		t.Fatalf("Unexpected comment %#v", ct)
+	}
+
+	hide := true
+	tests := []struct {
+		ct  CtDirectUserSearch
+		ids []string
+	}{
+		{CtDirectUserSearch{}, []string{"1", "abc"}},
+		{CtDirectUserSearch{Query: "CATS"}, []string{"1"}},
+		{CtDirectUserSearch{Tag: "mod"}, []string{"1"}},
+		{CtDirectUserSearch{Hide: &hide}, []string{"abc"}},
+		{CtDirectUserSearch{Limit: 1}, []string{"1"}},
+		{CtDirectUserSearch{Query: "none"}, nil},
+	}
+	for i, test := range tests {
+		us, err := cv.prcdnle.userDB.Search(test.ct.match, test.ct.Limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, u := range us {
+			ids = append(ids, u.ID)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%d : expected %v but %v", i, test.ids, ids)
+		}
+	}
+}
+
+func TestUserSearchMatch(t *testing.T) {
+	tm := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	us := []*nicolive.User{
+		{ID: "1", Name: "alice", Communities: map[string]*nicolive.UserCommunity{
+			"co1": {LastSeen: tm}, "co2": {LastSeen: tm.Add(time.Hour)},
+		}},
+		{ID: "abc", Is184: true, CommunityNames: map[string]string{"co1": "bob"}},
+		{ID: "2", Communities: map[string]*nicolive.UserCommunity{"co1": {LastSeen: tm.Add(time.Minute)}}},
+	}
+	yes, no := true, false
+	after, before := tm.Add(30*time.Second), tm.Add(30*time.Minute)
+
+	tests := []struct {
+		ct  CtDirectUserSearch
+		ids []string
+	}{
+		{CtDirectUserSearch{}, []string{"1", "abc", "2"}},
+		{CtDirectUserSearch{Is184: &yes}, []string{"abc"}},
+		{CtDirectUserSearch{Is184: &no}, []string{"1", "2"}},
+		{CtDirectUserSearch{HasName: &yes}, []string{"1", "abc"}},
+		{CtDirectUserSearch{HasName: &no}, []string{"2"}},
+		{CtDirectUserSearch{Query: "bo"}, []string{"abc"}},
+		{CtDirectUserSearch{SeenAfter: &after}, []string{"1", "2"}},
+		{CtDirectUserSearch{SeenAfter: &after, Community: "co1"}, []string{"2"}},
+		{CtDirectUserSearch{SeenBefore: &before, Community: "co1"}, []string{"1", "2"}},
+		{CtDirectUserSearch{SeenAfter: &after, SeenBefore: &before}, []string{"2"}},
+	}
+	for i, test := range tests {
+		var ids []string
+		for _, u := range us {
+			if test.ct.match(u) {
+				ids = append(ids, u.ID)
+			}
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%d : expected %v but %v", i, test.ids, ids)
+		}
+	}
+}
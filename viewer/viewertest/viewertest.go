@@ -0,0 +1,385 @@
+// Package viewertest provides a harness to test Nagome and plugins without connecting to Niconico.
+//
+// A Harness runs a CommentViewer with a temporary save directory.
+// The user DB and the plugin storage are kept in memory, so harnesses don't collide on them.
+// Fake plugins are attached to it through pipes, and nicolive events are injected
+// as if they came from a comment connection.
+//
+//	h := viewertest.New(t)
+//	defer h.Close()
+//	main := h.AddPlugin(viewertest.PluginConfig{
+//		Name:      "main",
+//		Subscribe: []string{viewer.DomainComment},
+//	})
+//	h.Start()
+//	h.Comment(nicolive.Comment{No: 1, UserID: "1", Comment: "hello"})
+//	var ct viewer.CtCommentGot
+//	main.ExpectContent(viewer.DomainComment, viewer.CommCommentGot, &ct)
+package viewertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+	"github.com/diginatu/nagome/viewer"
+)
+
+// DefaultTimeout is the default duration to wait for an expected message.
+const DefaultTimeout = 3 * time.Second
+
+// Harness is a running CommentViewer with fake plugins.
+type Harness struct {
+	CV      *viewer.CommentViewer
+	CLI     *viewer.CLI
+	Timeout time.Duration // Duration to wait for expected messages.
+
+	t       testing.TB
+	dir     string
+	plugins []*Plugin
+	started bool
+	closed  bool
+}
+
+// New makes a Harness with a temporary save directory.
+// The CommentViewer is not started until Start is called so that plugins can be added before.
+// The debug log is written to the test log only in the verbose mode.
+func New(t testing.TB) *Harness {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "nagometest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := viewer.NewCLI("", "nagometest")
+	cli.SavePath = dir
+	cli.MemoryStorage = true
+	cli.InStream = ioutil.NopCloser(nil)
+	cli.OutStream = nopWriteCloser{ioutil.Discard}
+	cli.ErrStream = nopWriteCloser{ioutil.Discard}
+	if testing.Verbose() {
+		cli.SetLogOutput(logWriter{t})
+	} else {
+		cli.SetLogOutput(ioutil.Discard)
+	}
+
+	cv := viewer.NewCommentViewer("0", cli)
+	cv.Ac = new(nicolive.Account)
+	// Fake plugins don't answer pings unless a test scripts it.
+	cv.Settings.PluginPingInterval = 0
+
+	return &Harness{
+		CV:      cv,
+		CLI:     cli,
+		Timeout: DefaultTimeout,
+		t:       t,
+		dir:     dir,
+	}
+}
+
+// Start starts the CommentViewer.
+func (h *Harness) Start() {
+	h.t.Helper()
+	if h.started {
+		h.t.Fatal("viewertest: harness is already started")
+	}
+	h.started = true
+	h.CV.Start()
+}
+
+// Close quits the CommentViewer and removes the save directory.
+func (h *Harness) Close() {
+	h.t.Helper()
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	h.CV.Quit()
+	if !h.started {
+		for _, p := range h.plugins {
+			p.Plugin.Close()
+		}
+	}
+	h.CV.Wait()
+	for _, p := range h.plugins {
+		p.Close()
+	}
+	if err := os.RemoveAll(h.dir); err != nil {
+		h.t.Error(err)
+	}
+}
+
+// SavePath returns the temporary save directory.
+func (h *Harness) SavePath() string {
+	return h.dir
+}
+
+// Event injects a nicolive event as if it came from a connection.
+func (h *Harness) Event(ev *nicolive.Event) {
+	h.CV.NicoliveEventReceiver().ProceedNicoEvent(ev)
+}
+
+// Comment injects a received comment.
+func (h *Harness) Comment(c nicolive.Comment) {
+	h.Event(&nicolive.Event{Type: nicolive.EventTypeCommentGot, Content: c})
+}
+
+// PluginConfig is the configuration of a fake plugin.
+// The fields are same as the ones in plugin.yml.
+type PluginConfig struct {
+	Name        string
+	Subscribe   []string
+	Domains     []string
+	Permissions []string // They are granted without asking.
+	Backlog     bool
+	Disabled    bool // Add the plugin in disabled state.
+}
+
+// AddPlugin attaches a fake plugin to the CommentViewer.
+// The first added plugin will be the main plugin.
+// Plugins have to be added before Start.
+func (h *Harness) AddPlugin(c PluginConfig) *Plugin {
+	h.t.Helper()
+	if h.started {
+		h.t.Fatal("viewertest: plugins have to be added before Start")
+	}
+
+	pl := viewer.NewPlugin(h.CV)
+	pl.Name = c.Name
+	pl.Description = "viewertest fake plugin"
+	pl.Version = "0.0"
+	pl.Subscribe = c.Subscribe
+	pl.Domains = c.Domains
+	pl.Permissions = c.Permissions
+	pl.Granted = c.Permissions
+	pl.Backlog = c.Backlog
+	h.CV.AddPlugin(pl)
+
+	// Nagome reads from nr and writes to nw.
+	nr, pw := io.Pipe()
+	pr, nw := io.Pipe()
+	p := &Plugin{
+		Plugin: pl,
+		h:      h,
+		w:      pw,
+		r:      pr,
+		enc:    json.NewEncoder(pw),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	h.plugins = append(h.plugins, p)
+	go p.readRoutine()
+
+	if err := pl.Open(&pipeRwc{nr, nw}, !c.Disabled); err != nil {
+		h.t.Fatal(err)
+	}
+	return p
+}
+
+// Plugin is a fake plugin connected to the Harness.
+type Plugin struct {
+	Plugin *viewer.Plugin // Plugin in the CommentViewer
+
+	h   *Harness
+	w   *io.PipeWriter
+	r   *io.PipeReader
+	enc *json.Encoder
+	wmu sync.Mutex
+
+	mu       sync.Mutex
+	queue    []*viewer.Message
+	received []*viewer.Message
+	filters  map[string]func(*viewer.Message) *viewer.Message
+	replies  map[string]func(*viewer.Message) []*viewer.Message
+	notify   chan struct{}
+	done     chan struct{}
+}
+
+func (p *Plugin) readRoutine() {
+	defer close(p.done)
+	dec := json.NewDecoder(p.r)
+	for {
+		m := new(viewer.Message)
+		if err := dec.Decode(m); err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		p.queue = append(p.queue, m)
+		p.received = append(p.received, m)
+		filter := p.filters[m.Domain]
+		reply := p.replies[m.Domain+" "+m.Command]
+		p.mu.Unlock()
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+
+		if filter != nil {
+			if fm := filter(m); fm != nil {
+				if fm.Token == "" {
+					fm.Token = m.Token
+				}
+				p.write(fm)
+			}
+		}
+		if reply != nil {
+			for _, rm := range reply(m) {
+				p.write(rm)
+			}
+		}
+	}
+}
+
+func (p *Plugin) write(m *viewer.Message) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	return p.enc.Encode(m)
+}
+
+// Send sends a message from the plugin to Nagome.
+// ct is marshaled into the content unless it is nil.
+func (p *Plugin) Send(dom, com string, ct interface{}) {
+	p.h.t.Helper()
+	m, err := viewer.NewMessage(dom, com, ct)
+	if err != nil {
+		p.h.t.Fatal(err)
+	}
+	p.SendMessage(m)
+}
+
+// SendMessage sends m from the plugin to Nagome as it is.
+func (p *Plugin) SendMessage(m *viewer.Message) {
+	p.h.t.Helper()
+	if err := p.write(m); err != nil {
+		p.h.t.Fatalf("viewertest: [%s] failed to send %s %s : %v", p.Plugin.Name, m.Domain, m.Command, err)
+	}
+}
+
+// Filter sets f as the filter of messages in dom, which must be a "@filter" domain that the plugin subscribes.
+// The message returned by f is sent back to Nagome with the token of the filtered message.  Returning nil drops the message.
+func (p *Plugin) Filter(dom string, f func(*viewer.Message) *viewer.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.filters == nil {
+		p.filters = make(map[string]func(*viewer.Message) *viewer.Message)
+	}
+	p.filters[dom] = f
+}
+
+// Reply makes the plugin send messages returned by f whenever it receives a message of dom and com.
+func (p *Plugin) Reply(dom, com string, f func(*viewer.Message) []*viewer.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.replies == nil {
+		p.replies = make(map[string]func(*viewer.Message) []*viewer.Message)
+	}
+	p.replies[dom+" "+com] = f
+}
+
+// next returns the next message of dom and com in the queue, skipping other messages.
+// Empty dom or com matches any.
+func (p *Plugin) next(dom, com string, du time.Duration) *viewer.Message {
+	tm := time.NewTimer(du)
+	defer tm.Stop()
+	for {
+		p.mu.Lock()
+		for len(p.queue) > 0 {
+			m := p.queue[0]
+			p.queue = p.queue[1:]
+			if (dom == "" || m.Domain == dom) && (com == "" || m.Command == com) {
+				p.mu.Unlock()
+				return m
+			}
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.notify:
+		case <-tm.C:
+			return nil
+		}
+	}
+}
+
+// Expect waits for the plugin to receive a message of dom and com, and returns it.
+// Messages received before it which don't match are discarded.
+// Empty dom or com matches any.
+func (p *Plugin) Expect(dom, com string) *viewer.Message {
+	p.h.t.Helper()
+	m := p.next(dom, com, p.h.Timeout)
+	if m == nil {
+		p.h.t.Fatalf("viewertest: [%s] did not receive %s %s in %v", p.Plugin.Name, dom, com, p.h.Timeout)
+	}
+	return m
+}
+
+// ExpectContent is same as Expect but also unmarshals the content into v.
+func (p *Plugin) ExpectContent(dom, com string, v interface{}) *viewer.Message {
+	p.h.t.Helper()
+	m := p.Expect(dom, com)
+	if err := json.Unmarshal(m.Content, v); err != nil {
+		p.h.t.Fatalf("viewertest: [%s] invalid content of %s : %v", p.Plugin.Name, m, err)
+	}
+	return m
+}
+
+// ExpectNone asserts that the plugin receives no message of dom and com in du.
+func (p *Plugin) ExpectNone(dom, com string, du time.Duration) {
+	p.h.t.Helper()
+	if m := p.next(dom, com, du); m != nil {
+		p.h.t.Fatalf("viewertest: [%s] received unexpected message %s", p.Plugin.Name, m)
+	}
+}
+
+// Received returns all messages the plugin has received so far.
+func (p *Plugin) Received() []*viewer.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*viewer.Message(nil), p.received...)
+}
+
+// Close closes the connection from the plugin side.
+func (p *Plugin) Close() {
+	p.w.Close()
+	p.r.Close()
+	<-p.done
+}
+
+func (p *Plugin) String() string {
+	return fmt.Sprintf("viewertest plugin [%s] No %d", p.Plugin.Name, p.Plugin.No)
+}
+
+type pipeRwc struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (rwc *pipeRwc) Close() error {
+	rwc.PipeReader.Close()
+	return rwc.PipeWriter.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type logWriter struct {
+	t testing.TB
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.t.Logf("%s", p)
+	return len(p), nil
+}
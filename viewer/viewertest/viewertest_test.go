@@ -0,0 +1,266 @@
+package viewertest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/diginatu/nagome/nicolive"
+	"github.com/diginatu/nagome/viewer"
+)
+
+func TestHarnessComment(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainNagome, viewer.DomainComment},
+	})
+	other := h.AddPlugin(PluginConfig{
+		Name:      "other",
+		Subscribe: []string{viewer.DomainNagome},
+	})
+	h.Start()
+
+	h.Comment(nicolive.Comment{No: 1, UserID: "1", Comment: "hello"})
+
+	var ct viewer.CtCommentGot
+	main.ExpectContent(viewer.DomainComment, viewer.CommCommentGot, &ct)
+	if ct.No != 1 || ct.Comment != "hello" || ct.UserID != "1" {
+		t.Errorf("unexpected comment : %+v", ct)
+	}
+	other.ExpectNone(viewer.DomainComment, "", 100*time.Millisecond)
+}
+
+func TestHarnessFilter(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainComment},
+	})
+	filter := h.AddPlugin(PluginConfig{
+		Name:      "filter",
+		Subscribe: []string{viewer.DomainComment + viewer.DomainSuffixFilter},
+	})
+	filter.Filter(viewer.DomainComment+viewer.DomainSuffixFilter, func(m *viewer.Message) *viewer.Message {
+		var ct viewer.CtCommentGot
+		if err := json.Unmarshal(m.Content, &ct); err != nil {
+			t.Error(err)
+			return nil
+		}
+		if ct.Comment == "spam" {
+			return nil
+		}
+		ct.Comment = "filtered " + ct.Comment
+		fm, err := viewer.NewMessage(m.Domain, m.Command, ct)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return fm
+	})
+	h.Start()
+
+	h.Comment(nicolive.Comment{No: 1, UserID: "1", Comment: "spam"})
+	h.Comment(nicolive.Comment{No: 2, UserID: "1", Comment: "hello"})
+
+	var ct viewer.CtCommentGot
+	main.ExpectContent(viewer.DomainComment, viewer.CommCommentGot, &ct)
+	if ct.No != 2 || ct.Comment != "filtered hello" {
+		t.Errorf("unexpected comment : %+v", ct)
+	}
+}
+
+func TestHarnessDirect(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	h.AddPlugin(PluginConfig{Name: "main"})
+	p := h.AddPlugin(PluginConfig{Name: "p"})
+	h.Start()
+
+	p.Send(viewer.DomainDirect, viewer.CommDirectStateGet, nil)
+	var ct viewer.CtDirectngmStateGet
+	p.ExpectContent(viewer.DomainDirectngm, viewer.CommDirectngmStateGet, &ct)
+	if ct.Connected {
+		t.Errorf("should not be connected : %+v", ct)
+	}
+}
+
+func TestHarnessPermissionThroughFilter(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainNagome},
+	})
+	filter := h.AddPlugin(PluginConfig{
+		Name:        "filter",
+		Subscribe:   []string{viewer.DomainQuery + viewer.DomainSuffixFilter},
+		Permissions: []string{viewer.PluginPermQueryFilter},
+	})
+	filter.Filter(viewer.DomainQuery+viewer.DomainSuffixFilter, func(m *viewer.Message) *viewer.Message {
+		return m
+	})
+	p := h.AddPlugin(PluginConfig{Name: "p"})
+	h.Start()
+
+	// The permission of the main plugin is kept through the filter without the permission.
+	main.Send(viewer.DomainQuery, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "main"})
+	filter.Expect(viewer.DomainQuery+viewer.DomainSuffixFilter, viewer.CommQueryUserSetNote)
+	var ct viewer.CtNagomeUserUpdate
+	main.ExpectContent(viewer.DomainNagome, viewer.CommNagomeUserUpdate, &ct)
+	if ct.Note != "main" {
+		t.Errorf("unexpected user : %+v", ct.User)
+	}
+	filter.ExpectNone(viewer.DomainDirectngm, viewer.CommDirectngmError, 100*time.Millisecond)
+
+	// A plugin without the permission is rejected before the filter.
+	p.Send(viewer.DomainQuery, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "p"})
+	p.Expect(viewer.DomainDirectngm, viewer.CommDirectngmError)
+	filter.ExpectNone(viewer.DomainQuery+viewer.DomainSuffixFilter, viewer.CommQueryUserSetNote, 100*time.Millisecond)
+	main.ExpectNone(viewer.DomainNagome, viewer.CommNagomeUserUpdate, 100*time.Millisecond)
+}
+
+func TestHarnessFilterToken(t *testing.T) {
+	const fdom = viewer.DomainQuery + viewer.DomainSuffixFilter
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainNagome},
+	})
+	filter := h.AddPlugin(PluginConfig{
+		Name:        "filter",
+		Subscribe:   []string{fdom},
+		Permissions: []string{viewer.PluginPermQueryFilter},
+	})
+	filter.Filter(fdom, func(m *viewer.Message) *viewer.Message {
+		return nil
+	})
+	h.Start()
+
+	// The filter drops the query of the main plugin and sends its own one.
+	main.Send(viewer.DomainQuery, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "main"})
+	filter.Expect(fdom, viewer.CommQueryUserSetNote)
+	filter.Send(fdom, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "filter"})
+	filter.Expect(viewer.DomainDirectngm, viewer.CommDirectngmError)
+
+	m, err := viewer.NewMessage(fdom, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "filter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Token = "unknown"
+	filter.SendMessage(m)
+	filter.Expect(viewer.DomainDirectngm, viewer.CommDirectngmError)
+	main.ExpectNone(viewer.DomainNagome, viewer.CommNagomeUserUpdate, 100*time.Millisecond)
+}
+
+func TestHarnessQueryFilterPermission(t *testing.T) {
+	const fdom = viewer.DomainQuery + viewer.DomainSuffixFilter
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainNagome},
+	})
+	filter := h.AddPlugin(PluginConfig{
+		Name:      "filter",
+		Subscribe: []string{fdom},
+	})
+	filter.Filter(fdom, func(m *viewer.Message) *viewer.Message {
+		return nil
+	})
+	h.Start()
+
+	// Queries are not sent to the filter without the permission.
+	main.Send(viewer.DomainQuery, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "main"})
+	main.Expect(viewer.DomainNagome, viewer.CommNagomeUserUpdate)
+	filter.ExpectNone(fdom, viewer.CommQueryUserSetNote, 100*time.Millisecond)
+}
+
+func TestHarnessTimer(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	p := h.AddPlugin(PluginConfig{Name: "p"})
+	h.Start()
+
+	p.Send(viewer.DomainDirect, viewer.CommDirectTimerSet, viewer.CtDirectTimerSet{Name: "once"})
+	var ct viewer.CtDirectngmTimerFired
+	p.ExpectContent(viewer.DomainDirectngm, viewer.CommDirectngmTimerFired, &ct)
+	if ct.Name != "once" || ct.Count != 1 {
+		t.Errorf("unexpected timer : %+v", ct)
+	}
+}
+
+func TestHarnessPlugSettingsGet(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{Name: "main"})
+	a := h.AddPlugin(PluginConfig{Name: "a"})
+	b := h.AddPlugin(PluginConfig{Name: "b"})
+	h.Start()
+
+	// Own settings
+	a.Send(viewer.DomainDirect, viewer.CommDirectPlugSettingsGet, nil)
+	var ct viewer.CtDirectngmPlugSettingsGet
+	a.ExpectContent(viewer.DomainDirectngm, viewer.CommDirectngmPlugSettingsGet, &ct)
+	if ct.Name != "a" {
+		t.Errorf("unexpected settings : %+v", ct)
+	}
+
+	// Settings of other plugins need the permission
+	b.Send(viewer.DomainDirect, viewer.CommDirectPlugSettingsGet, viewer.CtDirectPlugSettingsGet{Name: "a"})
+	b.Expect(viewer.DomainDirectngm, viewer.CommDirectngmError)
+	main.Send(viewer.DomainDirect, viewer.CommDirectPlugSettingsGet, viewer.CtDirectPlugSettingsGet{Name: "a"})
+	main.ExpectContent(viewer.DomainDirectngm, viewer.CommDirectngmPlugSettingsGet, &ct)
+	if ct.Name != "a" {
+		t.Errorf("unexpected settings : %+v", ct)
+	}
+}
+
+func TestHarnessMemoryStorage(t *testing.T) {
+	h1 := New(t)
+	defer h1.Close()
+	h2 := New(t)
+	defer h2.Close()
+	for _, h := range []*Harness{h1, h2} {
+		main := h.AddPlugin(PluginConfig{
+			Name:      "main",
+			Subscribe: []string{viewer.DomainNagome},
+		})
+		h.Start()
+		main.Send(viewer.DomainQuery, viewer.CommQueryUserSetNote, viewer.CtQueryUserSetNote{ID: "1", Note: "note"})
+		main.Expect(viewer.DomainNagome, viewer.CommNagomeUserUpdate)
+
+		fs, err := ioutil.ReadDir(h.SavePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range fs {
+			if f.Name() == "userdb" || f.Name() == "plugindata" {
+				t.Errorf("%s should be kept in memory", f.Name())
+			}
+		}
+	}
+}
+
+func TestHarnessBacklogBroadClose(t *testing.T) {
+	h := New(t)
+	defer h.Close()
+	main := h.AddPlugin(PluginConfig{
+		Name:      "main",
+		Subscribe: []string{viewer.DomainNagome, viewer.DomainComment},
+	})
+	h.Start()
+
+	h.Comment(nicolive.Comment{No: 1, UserID: "1", Comment: "hello"})
+	main.Expect(viewer.DomainComment, viewer.CommCommentGot)
+	h.Event(&nicolive.Event{Type: nicolive.EventTypeCommentClose})
+	main.Expect(viewer.DomainNagome, viewer.CommNagomeBroadClose)
+
+	// Comments of the closed broadcast are not replayed.
+	main.Send(viewer.DomainDirect, viewer.CommDirectCommentBacklog, nil)
+	main.ExpectNone(viewer.DomainComment, viewer.CommCommentGot, 100*time.Millisecond)
+}